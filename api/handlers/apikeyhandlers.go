@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/models"
+	"booking-service/repository"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+type CreateAPIKeyResponse struct {
+	models.APIKey
+	// Key is only ever returned here; it cannot be recovered later
+	Key string `json:"key"`
+}
+
+// CreateAPIKey issues a long-lived API key for the caller. The scopes must be
+// permissions the caller already has. A key created in an organization is
+// pinned to it, so scopes granted by the caller's role there only apply there.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if principal.AuthMethod == auth.AuthMethodAPIKey {
+		respondWithError(w, http.StatusForbidden, "API keys cannot create API keys")
+		return
+	}
+
+	var createRequest CreateAPIKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&createRequest)
+	if err != nil || strings.TrimSpace(createRequest.Name) == "" || createRequest.ExpiresInDays < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	for _, scope := range createRequest.Scopes {
+		if !principal.Can(scope) {
+			respondWithError(w, http.StatusForbidden, "Cannot grant scope: "+scope)
+			return
+		}
+	}
+
+	key, prefix, secretHash, err := auth.GenerateAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate API key")
+		return
+	}
+
+	apiKey := models.APIKey{
+		UserID:     principal.UserID,
+		Name:       strings.TrimSpace(createRequest.Name),
+		Prefix:     prefix,
+		SecretHash: secretHash,
+		Scopes:     createRequest.Scopes,
+	}
+	if principal.Tenant.IsSet() {
+		apiKey.OrganizationID = &principal.Tenant.ID
+	}
+	if createRequest.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, createRequest.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	keyRepo := repository.NewAPIKeyRepository(db)
+	apiKey, err = keyRepo.InsertAPIKey(apiKey)
+	if err != nil {
+		log.Printf("Failed to create API key for user %s: %s", principal.UserID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, CreateAPIKeyResponse{APIKey: apiKey, Key: key})
+}
+
+// GetAPIKeys lists the caller's active API keys without their secrets
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	keyRepo := repository.NewAPIKeyRepository(db)
+	keys, err := keyRepo.GetAPIKeysByUser(principal.UserID)
+	if err != nil {
+		log.Printf("Failed to fetch API keys for user %s: %s", principal.UserID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to get API keys")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// RevokeAPIKey revokes one of the caller's API keys
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	keyID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	keyRepo := repository.NewAPIKeyRepository(db)
+	revoked, err := keyRepo.RevokeAPIKey(principal.UserID, keyID)
+	if err != nil {
+		log.Printf("Failed to revoke API key %s: %s", keyID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "API key not found with ID: "+keyID.String())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"booking-service/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// userETag returns the strong entity tag of the user's current version
+func userETag(user models.User) string {
+	return `"` + strconv.Itoa(user.Version) + `"`
+}
+
+// ifMatchVersion reads the version a write is conditional on from its
+// If-Match header, returning 0 for "*". Writes without the header are
+// rejected with 428 so clients cannot overwrite changes they have not seen.
+// ok is false when a response has already been written.
+func ifMatchVersion(w http.ResponseWriter, r *http.Request) (version int, ok bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		respondWithError(w, http.StatusPreconditionRequired, "If-Match header is required")
+		return 0, false
+	}
+	if header == "*" {
+		return 0, true
+	}
+
+	if strings.Contains(header, ",") {
+		respondWithError(w, http.StatusBadRequest, "If-Match must name a single entity tag")
+		return 0, false
+	}
+	// Weak tags never match under If-Match's strong comparison
+	version, err := strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil || version < 1 || !strings.HasPrefix(header, `"`) {
+		respondWithError(w, http.StatusPreconditionFailed, "If-Match does not match the current version")
+		return 0, false
+	}
+	return version, true
+}
+
+// notModified reports whether the request's If-None-Match names etag
+func notModified(r *http.Request, etag string) bool {
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
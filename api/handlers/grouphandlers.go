@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/models"
+	"booking-service/repository"
+	"database/sql"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+)
+
+type GroupRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	// BookingQuota is how many active bookings each member may hold; null sets no quota
+	BookingQuota *int `json:"booking_quota"`
+}
+
+type GroupMemberRequest struct {
+	UserID uuid.UUID `json:"user_id"`
+}
+
+type SubgroupRequest struct {
+	GroupID uuid.UUID `json:"group_id"`
+}
+
+// UserGroupsResponse lists a user's groups and what they are granted through them
+type UserGroupsResponse struct {
+	Groups      []models.Group `json:"groups"`
+	Permissions []string       `json:"permissions"`
+	// BookingQuota is the largest quota among the groups, or null if none sets one
+	BookingQuota *int `json:"booking_quota"`
+}
+
+// scopedGroupRepository returns a group repository limited to the organization
+// the request's session acts in
+func scopedGroupRepository(conn *sql.DB, r *http.Request) *repository.GroupRepository {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	return repository.NewGroupRepository(conn).ForTenant(principal.Tenant.ID)
+}
+
+// checkGrantable fails unless every permission is one the API checks and the
+// principal holds every permission it is newly granting, so nobody can hand
+// out more than they have
+func checkGrantable(principal auth.Principal, permissions, current []string) error {
+	for _, permission := range permissions {
+		if !auth.IsPermission(permission) {
+			return &repository.Error{Kind: repository.ErrValidation, Message: "invalid group", Fields: map[string]string{"permissions": "unknown permission " + permission}}
+		}
+		if !slices.Contains(current, permission) && !principal.Can(permission) {
+			return &repository.Error{Kind: repository.ErrForbidden, Message: "cannot grant a permission you do not have: " + permission}
+		}
+	}
+	return nil
+}
+
+// checkJoinable fails unless the principal holds everything that joining the
+// group grants, so nobody can gain a permission by adding members or nesting
+// groups inside it
+func checkJoinable(groupRepo *repository.GroupRepository, principal auth.Principal, groupID uuid.UUID) error {
+	permissions, err := groupRepo.InheritedPermissions(groupID)
+	if err != nil {
+		return err
+	}
+	return checkGrantable(principal, permissions, nil)
+}
+
+func groupFromRequest(r *http.Request) (models.Group, bool) {
+	var groupRequest GroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&groupRequest); err != nil {
+		return models.Group{}, false
+	}
+	return models.Group{
+		Name:         groupRequest.Name,
+		Description:  groupRequest.Description,
+		Permissions:  groupRequest.Permissions,
+		BookingQuota: groupRequest.BookingQuota,
+	}, true
+}
+
+// CreateGroup creates a group in the caller's organization, or a global group
+// for callers outside any organization
+func CreateGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := groupFromRequest(r)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if err := checkGrantable(principal, group.Permissions, nil); err != nil {
+		respondWithDomainError(w, err, "create group")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	group, err = scopedGroupRepository(db, r).InsertGroup(group)
+	if err != nil {
+		respondWithDomainError(w, err, "create group")
+		return
+	}
+
+	log.Printf("User %s created group %s with permissions %v", principal.UserID, group.ID, group.Permissions)
+	respondWithJSON(w, http.StatusCreated, group)
+}
+
+func GetGroups(w http.ResponseWriter, r *http.Request) {
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	groups, err := scopedGroupRepository(db, r).GetGroups()
+	if err != nil {
+		respondWithDomainError(w, err, "get groups")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, groups)
+}
+
+func GetGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	group, err := scopedGroupRepository(db, r).GetGroup(groupID)
+	if err != nil {
+		respondWithDomainError(w, err, "fetch group")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, group)
+}
+
+// UpdateGroup replaces a group's name, description, permissions and booking quota
+func UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	group, ok := groupFromRequest(r)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	group.ID = groupID
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	groupRepo := scopedGroupRepository(db, r)
+	existing, err := groupRepo.GetGroup(groupID)
+	if err != nil {
+		respondWithDomainError(w, err, "update group")
+		return
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if err := checkGrantable(principal, group.Permissions, existing.Permissions); err != nil {
+		respondWithDomainError(w, err, "update group")
+		return
+	}
+
+	group, err = groupRepo.UpdateGroup(group)
+	if err != nil {
+		respondWithDomainError(w, err, "update group")
+		return
+	}
+
+	log.Printf("User %s updated group %s with permissions %v", principal.UserID, group.ID, group.Permissions)
+	respondWithJSON(w, http.StatusOK, group)
+}
+
+func DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	if err := scopedGroupRepository(db, r).DeleteGroup(groupID); err != nil {
+		respondWithDomainError(w, err, "delete group")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func GetGroupMembers(w http.ResponseWriter, r *http.Request) {
+	groupID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	members, err := scopedGroupRepository(db, r).GetGroupMembers(groupID)
+	if err != nil {
+		respondWithDomainError(w, err, "get group members")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+func AddGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	var memberRequest GroupMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&memberRequest); err != nil || memberRequest.UserID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	groupRepo := scopedGroupRepository(db, r)
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if err := checkJoinable(groupRepo, principal, groupID); err != nil {
+		respondWithDomainError(w, err, "add group member")
+		return
+	}
+
+	member, err := groupRepo.AddGroupMember(groupID, memberRequest.UserID)
+	if err != nil {
+		respondWithDomainError(w, err, "add group member")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, member)
+}
+
+func RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	userID, err := uuid.Parse(vars["user_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	if err := scopedGroupRepository(db, r).RemoveGroupMember(groupID, userID); err != nil {
+		respondWithDomainError(w, err, "remove group member")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func GetSubgroups(w http.ResponseWriter, r *http.Request) {
+	groupID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	groups, err := scopedGroupRepository(db, r).GetSubgroups(groupID)
+	if err != nil {
+		respondWithDomainError(w, err, "get subgroups")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, groups)
+}
+
+// AddSubgroup nests another group inside the group, so its members inherit
+// what the group is granted
+func AddSubgroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	var subgroupRequest SubgroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&subgroupRequest); err != nil || subgroupRequest.GroupID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	groupRepo := scopedGroupRepository(db, r)
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if err := checkJoinable(groupRepo, principal, groupID); err != nil {
+		respondWithDomainError(w, err, "add subgroup")
+		return
+	}
+
+	if err := groupRepo.AddSubgroup(groupID, subgroupRequest.GroupID); err != nil {
+		respondWithDomainError(w, err, "add subgroup")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func RemoveSubgroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	groupID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	childID, err := uuid.Parse(vars["child_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	if err := scopedGroupRepository(db, r).RemoveSubgroup(groupID, childID); err != nil {
+		respondWithDomainError(w, err, "remove subgroup")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetUserGroups lists the groups a user belongs to, directly or through
+// nesting, with the permissions and booking quota they grant
+func GetUserGroups(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	if _, err := scopedUserRepository(db, r).GetUserByID(userID); err != nil {
+		respondWithDomainError(w, err, "get user groups")
+		return
+	}
+	groups, err := scopedGroupRepository(db, r).GetUserGroups(userID)
+	if err != nil {
+		respondWithDomainError(w, err, "get user groups")
+		return
+	}
+
+	response := UserGroupsResponse{Groups: groups, Permissions: []string{}}
+	for _, group := range groups {
+		for _, permission := range group.Permissions {
+			if !slices.Contains(response.Permissions, permission) {
+				response.Permissions = append(response.Permissions, permission)
+			}
+		}
+		if group.BookingQuota != nil && (response.BookingQuota == nil || *group.BookingQuota > *response.BookingQuota) {
+			response.BookingQuota = group.BookingQuota
+		}
+	}
+	sort.Strings(response.Permissions)
+
+	respondWithJSON(w, http.StatusOK, response)
+}
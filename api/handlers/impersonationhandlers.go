@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/models"
+	"booking-service/repository"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type ImpersonateRequest struct {
+	Reason string `json:"reason"`
+	// AllowDestructive lets the token change data; it is off by default
+	AllowDestructive bool `json:"allow_destructive"`
+}
+
+type ImpersonateResponse struct {
+	Token         string       `json:"token"`
+	ExpiresIn     int64        `json:"expires_in"`
+	Impersonating UserResponse `json:"impersonating"`
+}
+
+// Impersonate issues a short-lived token for an admin to act as another user
+func Impersonate(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if principal.IsImpersonated() || principal.AuthMethod != auth.AuthMethodJWT || principal.ClientID != "" {
+		respondWithError(w, http.StatusForbidden, "Impersonation requires a direct admin session")
+		return
+	}
+
+	targetID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	if targetID == principal.UserID {
+		respondWithError(w, http.StatusBadRequest, "Cannot impersonate yourself")
+		return
+	}
+
+	var impersonateRequest ImpersonateRequest
+	if err := json.NewDecoder(r.Body).Decode(&impersonateRequest); err != nil || strings.TrimSpace(impersonateRequest.Reason) == "" {
+		respondWithError(w, http.StatusBadRequest, "A reason is required")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	userRepo := scopedUserRepository(db, r)
+	target, err := userRepo.GetUserByID(targetID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found with ID: "+targetID.String())
+		return
+	}
+	// Impersonating another admin would let one admin act with another's privileges
+	if target.Role == models.RoleAdmin {
+		respondWithError(w, http.StatusForbidden, "Admins cannot be impersonated")
+		return
+	}
+
+	// The target is seen as they would be after signing in themselves
+	tenant, err := defaultTenant(db, target.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	token, tokenID, expiresAt, err := auth.GenerateImpersonationToken(principal, target.ID, []string{target.Role}, tenant, impersonateRequest.AllowDestructive)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	session := models.ImpersonationSession{
+		TokenID:          tokenID,
+		ActorID:          principal.UserID,
+		SubjectID:        target.ID,
+		Reason:           strings.TrimSpace(impersonateRequest.Reason),
+		AllowDestructive: impersonateRequest.AllowDestructive,
+		ExpiresAt:        expiresAt,
+	}
+	if tenant.IsSet() {
+		session.OrganizationID = &tenant.ID
+	}
+	impersonationRepo := repository.NewImpersonationRepository(db)
+	err = impersonationRepo.InsertSession(session)
+	if err != nil {
+		// No token is handed out without its audit record
+		log.Printf("Failed to record impersonation of %s by %s: %s", target.ID, principal.UserID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to start impersonation")
+		return
+	}
+
+	log.Printf("User %s started impersonating %s: %s", principal.UserID, target.ID, impersonateRequest.Reason)
+
+	respondWithJSON(w, http.StatusOK, ImpersonateResponse{
+		Token:         token,
+		ExpiresIn:     auth.ImpersonationTTLSeconds,
+		Impersonating: toUserResponse(target),
+	})
+}
+
+// GetImpersonations lists impersonation sessions, optionally filtered by ?user_id=
+func GetImpersonations(w http.ResponseWriter, r *http.Request) {
+	var subjectID *uuid.UUID
+	if idStr := r.URL.Query().Get("user_id"); idStr != "" {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+			return
+		}
+		subjectID = &id
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	sessions, err := repository.NewImpersonationRepository(db).GetSessions(subjectID)
+	if err != nil {
+		log.Printf("Failed to fetch impersonation sessions: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to get impersonations")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/mail"
+	"booking-service/models"
+	"booking-service/password"
+	"booking-service/repository"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"time"
+)
+
+// invitationTTL is how long an invitation link can be used
+const invitationTTL = 7 * 24 * time.Hour
+
+type CreateInvitationRequest struct {
+	Email string `json:"email"`
+	// Role is the organization role given on accepting; it defaults to member
+	Role string `json:"role"`
+}
+
+type AcceptInvitationRequest struct {
+	Token     string `json:"token"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	// Password is required when the invited address has no account yet
+	Password string `json:"password"`
+}
+
+type AcceptInvitationResponse struct {
+	Invitation models.Invitation `json:"invitation"`
+	User       UserResponse      `json:"user"`
+}
+
+// CreateInvitation invites an email address to join the organization and
+// emails the invitee a link to accept. Only owners can invite owners.
+func CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	orgID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !canManageMembers(principal, orgID) {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	var createRequest CreateInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&createRequest); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	email := strings.TrimSpace(createRequest.Email)
+	if addr, err := netmail.ParseAddress(email); err != nil || addr.Address != email {
+		respondWithError(w, http.StatusBadRequest, "A valid email address is required")
+		return
+	}
+	if createRequest.Role == "" {
+		createRequest.Role = models.OrgRoleMember
+	}
+	if !models.IsOrgRole(createRequest.Role) {
+		respondWithError(w, http.StatusBadRequest, "Unknown organization role: "+createRequest.Role)
+		return
+	}
+	if createRequest.Role == models.OrgRoleOwner && !principal.Can(auth.PermOrganizationsManage) && principal.Tenant.Role != models.OrgRoleOwner {
+		respondWithDomainError(w, errOwnerRoleForbidden, "create invitation")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	org, err := repository.NewOrganizationRepository(db).GetOrganization(orgID)
+	if err != nil {
+		respondWithDomainError(w, err, "create invitation")
+		return
+	}
+
+	invitation := models.Invitation{
+		OrganizationID: orgID,
+		Email:          email,
+		Role:           createRequest.Role,
+		ExpiresAt:      time.Now().Add(invitationTTL),
+	}
+	if principal.UserID != uuid.Nil {
+		invitation.InvitedBy = &principal.UserID
+	}
+	invitation, err = repository.NewInvitationRepository(db).InsertInvitation(invitation)
+	if err != nil {
+		respondWithDomainError(w, err, "create invitation")
+		return
+	}
+
+	token, err := auth.GenerateInvitationToken(invitation.ID, invitation.ExpiresAt)
+	if err != nil {
+		respondWithDomainError(w, err, "create invitation")
+		return
+	}
+	err = mailer.Send(mail.Message{
+		To:      invitation.Email,
+		Subject: "You have been invited to join " + org.Name,
+		Body:    fmt.Sprintf("Hi,\n\nYou have been invited to join %s. Accept the invitation by opening this link:\n\n%s\n\nThe link expires in 7 days.\n", org.Name, appURL("/invitations/accept", token)),
+	})
+	if err != nil {
+		log.Printf("Failed to send invitation %s: %s", invitation.ID, err)
+	}
+
+	log.Printf("User %s invited %s to organization %s as %s", principal.UserID, invitation.Email, orgID, invitation.Role)
+	respondWithJSON(w, http.StatusCreated, invitation)
+}
+
+// GetInvitations lists the organization's invitations, optionally filtered by ?status=
+func GetInvitations(w http.ResponseWriter, r *http.Request) {
+	orgID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !canManageMembers(principal, orgID) {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.InvitationPending, models.InvitationAccepted, models.InvitationExpired, models.InvitationRevoked:
+	default:
+		respondWithError(w, http.StatusBadRequest, "status must be pending, accepted, expired or revoked")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	invitations, err := repository.NewInvitationRepository(db).GetInvitations(orgID, status)
+	if err != nil {
+		respondWithDomainError(w, err, "get invitations")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, invitations)
+}
+
+// RevokeInvitation stops an invitation from being accepted
+func RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	invitationID, err := uuid.Parse(vars["invitation_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !canManageMembers(principal, orgID) {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	if _, err := repository.NewInvitationRepository(db).RevokeInvitation(orgID, invitationID); err != nil {
+		respondWithDomainError(w, err, "revoke invitation")
+		return
+	}
+
+	log.Printf("User %s revoked invitation %s", principal.UserID, invitationID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvitation uses the token from an invitation email. An invitee
+// without an account creates one by choosing a password; an existing user
+// just joins the organization and signs in as usual.
+func AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var acceptRequest AcceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&acceptRequest); err != nil || acceptRequest.Token == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	invitationID, err := auth.ParseInvitationToken(acceptRequest.Token)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired invitation")
+		return
+	}
+	if acceptRequest.Password != "" {
+		if err := password.ValidatePolicy(acceptRequest.Password); err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Password must be at least %d characters", password.MinLength))
+			return
+		}
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	invitation, user, err := repository.NewInvitationRepository(db).AcceptInvitation(invitationID, repository.InvitationAcceptance{
+		FirstName: strings.TrimSpace(acceptRequest.FirstName),
+		LastName:  strings.TrimSpace(acceptRequest.LastName),
+		Password:  acceptRequest.Password,
+	})
+	if err != nil {
+		respondWithDomainError(w, err, "accept invitation")
+		return
+	}
+
+	log.Printf("User %s accepted invitation %s to organization %s", user.ID, invitation.ID, invitation.OrganizationID)
+	respondWithJSON(w, http.StatusOK, AcceptInvitationResponse{
+		Invitation: invitation,
+		User:       toUserResponse(user),
+	})
+}
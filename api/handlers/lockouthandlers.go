@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/models"
+	"booking-service/repository"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loginCounters returns the account and IP counters a login attempt is tracked under
+func loginCounters(r *http.Request, username string) map[string]string {
+	return map[string]string{
+		auth.LockoutAccount: strings.ToLower(strings.TrimSpace(username)),
+		auth.LockoutIP:      clientIP(r),
+	}
+}
+
+// clientIP returns the address of the directly connected client
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// lockedUntil returns the latest lockout end across the counters, or nil if none is locked
+func lockedUntil(attemptRepo *repository.LoginAttemptRepository, counters map[string]string) (*time.Time, error) {
+	var latest *time.Time
+	for kind, key := range counters {
+		until, err := attemptRepo.GetLockedUntil(kind, key)
+		if err != nil {
+			return nil, err
+		}
+		if until != nil && (latest == nil || until.After(*latest)) {
+			latest = until
+		}
+	}
+	return latest, nil
+}
+
+// recordLoginFailure counts the failure on every counter and locks those past their threshold
+func recordLoginFailure(attemptRepo *repository.LoginAttemptRepository, counters map[string]string) {
+	for kind, key := range counters {
+		policy := auth.LockoutPolicies[kind]
+		failures, err := attemptRepo.RecordFailure(kind, key, policy.Window)
+		if err != nil {
+			log.Printf("Failed to record login failure for %s %s: %s", kind, key, err)
+			continue
+		}
+		if delay := policy.LockoutDuration(failures); delay > 0 {
+			log.Printf("Locking %s %s for %s after %d failed logins", kind, key, delay, failures)
+			if err := attemptRepo.Lock(kind, key, time.Now().Add(delay)); err != nil {
+				log.Printf("Failed to lock %s %s: %s", kind, key, err)
+			}
+		}
+	}
+}
+
+func respondLockedOut(w http.ResponseWriter, until time.Time) {
+	retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later")
+}
+
+// GetLockouts lists the accounts and IPs that are currently locked out
+func GetLockouts(w http.ResponseWriter, r *http.Request) {
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	attemptRepo := repository.NewLoginAttemptRepository(db)
+	lockouts, err := attemptRepo.GetActiveLockouts()
+	if err != nil {
+		log.Printf("Failed to fetch lockouts: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to get lockouts")
+		return
+	}
+	if lockouts == nil {
+		lockouts = []models.LoginAttempt{}
+	}
+
+	respondWithJSON(w, http.StatusOK, lockouts)
+}
+
+// ClearLockout resets the failure counter named by the kind and key query parameters
+func ClearLockout(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	key := r.URL.Query().Get("key")
+	if _, ok := auth.LockoutPolicies[kind]; !ok || key == "" {
+		respondWithError(w, http.StatusBadRequest, "kind must be account, ip or mfa and key is required")
+		return
+	}
+	if kind == auth.LockoutAccount {
+		key = strings.ToLower(key)
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	attemptRepo := repository.NewLoginAttemptRepository(db)
+	found, err := attemptRepo.Reset(kind, key)
+	if err != nil {
+		log.Printf("Failed to clear lockout for %s %s: %s", kind, key, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to clear lockout")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "No failed logins recorded for "+kind+" "+key)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"database/sql"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func TestMain(m *testing.M) {
+	// Without JWT_KEYS_DIR this signs with an ephemeral key
+	if err := auth.LoadKeysFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+// testDB returns a connection to the database the handlers use, skipping the
+// test when it is unreachable or its migrations have not been applied
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	conn, err := db.ConnectDB()
+	if err != nil {
+		t.Skipf("database unavailable: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.Ping(); err != nil {
+		t.Skipf("database unavailable: %s", err)
+	}
+	var migrated bool
+	query := `
+        SELECT EXISTS (
+            SELECT 1 FROM information_schema.columns
+            WHERE table_schema = 'public' AND table_name = 'oidc_login_state' AND column_name = 'link_user_id'
+        )
+    `
+	if err := conn.QueryRow(query).Scan(&migrated); err != nil || !migrated {
+		t.Skip("database migrations have not been applied")
+	}
+	return conn
+}
+
+// testEmail returns an address no other test run uses
+func testEmail(name string) string {
+	return name + "-" + uuid.NewString() + "@example.com"
+}
+
+// deleteTestUsers removes users created by a test along with what the
+// handlers under test store about them
+func deleteTestUsers(t *testing.T, conn *sql.DB, ids ...uuid.UUID) {
+	t.Helper()
+	for _, statement := range []string{
+		`DELETE FROM public.refresh_token WHERE user_id = ANY($1)`,
+		`DELETE FROM public.user_mfa WHERE user_id = ANY($1)`,
+		`DELETE FROM public.user_token WHERE user_id = ANY($1)`,
+		`DELETE FROM public.user_identity WHERE user_id = ANY($1)`,
+		`DELETE FROM public.organization_member WHERE user_id = ANY($1)`,
+		`DELETE FROM public."user" WHERE id = ANY($1)`,
+	} {
+		if _, err := conn.Exec(statement, pq.Array(ids)); err != nil {
+			t.Errorf("cleaning up test users: %s", err)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/password"
+	"booking-service/repository"
+	"booking-service/totp"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// totpIssuer is the account issuer shown in authenticator apps
+const totpIssuer = "booking-service"
+
+// recoveryCodeCount is the number of recovery codes issued when MFA is enabled
+const recoveryCodeCount = 10
+
+type TOTPEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// EnrollTOTP generates a new TOTP secret for the caller. MFA is not enforced
+// until the first code is confirmed through ConfirmTOTP.
+func EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	userRepo := repository.NewUserRepository(db)
+	user, err := userRepo.GetUserByID(principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate secret")
+		return
+	}
+
+	mfaRepo := repository.NewMFARepository(db)
+	saved, err := mfaRepo.SavePendingSecret(user.ID, secret)
+	if err != nil {
+		log.Printf("Failed to save TOTP secret for user %s: %s", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to enroll TOTP")
+		return
+	}
+	if !saved {
+		respondWithError(w, http.StatusConflict, "TOTP is already enabled")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, TOTPEnrollmentResponse{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(totpIssuer, user.Username, secret),
+	})
+}
+
+// ConfirmTOTP enables MFA once the user proves their authenticator works and
+// returns the recovery codes, which are never shown again
+func ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var confirmRequest struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&confirmRequest); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	// Wrong codes count towards the same lockout as at login
+	attemptRepo := repository.NewLoginAttemptRepository(db)
+	counters := mfaCounters(principal.UserID)
+	until, err := lockedUntil(attemptRepo, counters)
+	if err != nil {
+		log.Printf("Failed to check MFA lockout: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to confirm TOTP")
+		return
+	}
+	if until != nil {
+		respondLockedOut(w, *until)
+		return
+	}
+
+	mfaRepo := repository.NewMFARepository(db)
+	mfa, err := mfaRepo.GetMFA(principal.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "TOTP enrollment not started")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch MFA for user %s: %s", principal.UserID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to confirm TOTP")
+		return
+	}
+	if mfa.EnabledAt != nil {
+		respondWithError(w, http.StatusConflict, "TOTP is already enabled")
+		return
+	}
+
+	if !verifyTOTP(mfaRepo, mfa.UserID, mfa.Secret, confirmRequest.Code) {
+		recordLoginFailure(attemptRepo, counters)
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	resetMFAFailures(attemptRepo, mfa.UserID)
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+	if err := mfaRepo.EnableMFA(mfa.UserID, hashes); err != nil {
+		log.Printf("Failed to enable MFA for user %s: %s", mfa.UserID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to confirm TOTP")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTOTP turns MFA off for the caller after checking their password and
+// a current code, so a stolen session alone cannot remove the second factor
+func DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var disableRequest struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&disableRequest); err != nil || disableRequest.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	attemptRepo := repository.NewLoginAttemptRepository(db)
+	counters := mfaCounters(principal.UserID)
+	until, err := lockedUntil(attemptRepo, counters)
+	if err != nil {
+		log.Printf("Failed to check MFA lockout: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to disable TOTP")
+		return
+	}
+	if until != nil {
+		respondLockedOut(w, *until)
+		return
+	}
+
+	mfaRepo := repository.NewMFARepository(db)
+	mfa, err := mfaRepo.GetMFA(principal.UserID)
+	if err != nil || mfa.EnabledAt == nil {
+		respondWithError(w, http.StatusNotFound, "TOTP is not enabled")
+		return
+	}
+
+	user, err := repository.NewUserRepository(db).GetUserByID(mfa.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	match, _, err := password.Verify(user.Password, disableRequest.Password)
+	if err != nil {
+		log.Printf("Failed to verify password for user %s: %s", user.ID, err)
+	}
+	// Check the code even after a wrong password so neither can be probed alone
+	codeOK := verifyTOTP(mfaRepo, mfa.UserID, mfa.Secret, disableRequest.Code)
+	if !match || !codeOK {
+		recordLoginFailure(attemptRepo, counters)
+		respondWithError(w, http.StatusUnauthorized, "Invalid password or code")
+		return
+	}
+	resetMFAFailures(attemptRepo, mfa.UserID)
+
+	if err := mfaRepo.DisableMFA(mfa.UserID); err != nil {
+		log.Printf("Failed to disable MFA for user %s: %s", mfa.UserID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to disable TOTP")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LoginMFA exchanges the challenge token from Login and a TOTP or recovery code for access tokens
+func LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var mfaRequest struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&mfaRequest); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	userID, err := auth.ParseMFAChallenge(mfaRequest.MFAToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	// Limit guesses of the six digit code per user
+	attemptRepo := repository.NewLoginAttemptRepository(db)
+	counters := mfaCounters(userID)
+	until, err := lockedUntil(attemptRepo, counters)
+	if err != nil {
+		log.Printf("Failed to check MFA lockout: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to log in")
+		return
+	}
+	if until != nil {
+		respondLockedOut(w, *until)
+		return
+	}
+
+	mfaRepo := repository.NewMFARepository(db)
+	mfa, err := mfaRepo.GetMFA(userID)
+	if err != nil || mfa.EnabledAt == nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+
+	verified := false
+	if mfaRequest.RecoveryCode != "" {
+		verified, err = mfaRepo.UseRecoveryCode(userID, auth.HashToken(normalizeRecoveryCode(mfaRequest.RecoveryCode)))
+		if err != nil {
+			log.Printf("Failed to check recovery code for user %s: %s", userID, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to log in")
+			return
+		}
+	} else {
+		verified = verifyTOTP(mfaRepo, userID, mfa.Secret, mfaRequest.Code)
+	}
+	if !verified {
+		recordLoginFailure(attemptRepo, counters)
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	resetMFAFailures(attemptRepo, userID)
+
+	userRepo := repository.NewUserRepository(db)
+	user, err := userRepo.GetUserByID(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return
+	}
+
+	tokens, err := issueSessionTokens(db, user)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+// mfaChallenge returns the challenge to send instead of tokens when the user
+// has MFA enabled, or nil when the password alone is enough
+func mfaChallenge(mfaRepo *repository.MFARepository, userID uuid.UUID) (*MFAChallengeResponse, error) {
+	mfa, err := mfaRepo.GetMFA(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if mfa.EnabledAt == nil {
+		return nil, nil
+	}
+
+	token, err := auth.GenerateMFAChallenge(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   auth.MFAChallengeTTLSeconds,
+	}, nil
+}
+
+// mfaCounters returns the counter that wrong second-factor codes of the user
+// are tracked under, wherever they are entered
+func mfaCounters(userID uuid.UUID) map[string]string {
+	return map[string]string{auth.LockoutMFA: userID.String()}
+}
+
+func resetMFAFailures(attemptRepo *repository.LoginAttemptRepository, userID uuid.UUID) {
+	if _, err := attemptRepo.Reset(auth.LockoutMFA, userID.String()); err != nil {
+		log.Printf("Failed to reset MFA failures for user %s: %s", userID, err)
+	}
+}
+
+// verifyTOTP checks a code and records its time step so it cannot be used twice
+func verifyTOTP(mfaRepo *repository.MFARepository, userID uuid.UUID, secret, code string) bool {
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+	fresh, err := mfaRepo.UseStep(userID, step)
+	if err != nil {
+		log.Printf("Failed to record TOTP use for user %s: %s", userID, err)
+		return false
+	}
+	return fresh
+}
+
+// generateRecoveryCodes returns new recovery codes and the hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = auth.HashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts recovery codes with or without the dash and in any case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,692 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/models"
+	"booking-service/oidc"
+	"booking-service/repository"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OAuth grant types we support
+const (
+	grantAuthorizationCode = "authorization_code"
+	grantClientCredentials = "client_credentials"
+)
+
+// authorizationCodeTTL is how long a partner has to redeem an authorization code
+const authorizationCodeTTL = time.Minute
+
+// Reasons a token request cannot redeem an authorization code
+var (
+	errInvalidAuthorizationCode = errors.New("authorization code was issued to another client or redirect URI")
+	errPKCEVerificationFailed   = errors.New("code verifier does not match the code challenge")
+)
+
+type RegisterOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	// Confidential clients get a secret; public clients must rely on PKCE alone
+	Confidential bool `json:"confidential"`
+	// OrganizationID is required for client_credentials and defaults to the caller's organization
+	OrganizationID *uuid.UUID `json:"organization_id"`
+}
+
+type RegisterOAuthClientResponse struct {
+	models.OAuthClient
+	// ClientSecret is only ever returned here
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+type OAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	TokenID   string `json:"jti,omitempty"`
+}
+
+type AuthorizationRequest struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	// Approve is the user's answer on the consent screen
+	Approve bool `json:"approve"`
+}
+
+type AuthorizationResponse struct {
+	// RedirectTo is where the front end sends the browser next
+	RedirectTo      string   `json:"redirect_to,omitempty"`
+	ConsentRequired bool     `json:"consent_required,omitempty"`
+	ClientName      string   `json:"client_name,omitempty"`
+	Scopes          []string `json:"scopes,omitempty"`
+}
+
+// RegisterOAuthClient registers a partner application
+func RegisterOAuthClient(w http.ResponseWriter, r *http.Request) {
+	var registerRequest RegisterOAuthClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&registerRequest); err != nil || strings.TrimSpace(registerRequest.Name) == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if len(registerRequest.GrantTypes) == 0 {
+		registerRequest.GrantTypes = []string{grantAuthorizationCode}
+	}
+	for _, grantType := range registerRequest.GrantTypes {
+		if grantType != grantAuthorizationCode && grantType != grantClientCredentials {
+			respondWithError(w, http.StatusBadRequest, "Unsupported grant type: "+grantType)
+			return
+		}
+		if grantType == grantClientCredentials && !registerRequest.Confidential {
+			respondWithError(w, http.StatusBadRequest, "client_credentials requires a confidential client")
+			return
+		}
+		if grantType == grantAuthorizationCode && len(registerRequest.RedirectURIs) == 0 {
+			respondWithError(w, http.StatusBadRequest, "authorization_code requires at least one redirect URI")
+			return
+		}
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if registerRequest.OrganizationID == nil && principal.Tenant.IsSet() {
+		registerRequest.OrganizationID = &principal.Tenant.ID
+	}
+	if slices.Contains(registerRequest.GrantTypes, grantClientCredentials) && registerRequest.OrganizationID == nil {
+		respondWithError(w, http.StatusBadRequest, "client_credentials requires an organization_id")
+		return
+	}
+	for _, redirectURI := range registerRequest.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+			respondWithError(w, http.StatusBadRequest, "Invalid redirect URI: "+redirectURI)
+			return
+		}
+	}
+	for _, scope := range registerRequest.Scopes {
+		if !auth.IsOAuthScope(scope) {
+			respondWithError(w, http.StatusBadRequest, "Unknown scope: "+scope)
+			return
+		}
+	}
+
+	clientID, err := randomClientID()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to register client")
+		return
+	}
+	client := models.OAuthClient{
+		ID:             clientID,
+		Name:           strings.TrimSpace(registerRequest.Name),
+		RedirectURIs:   nonNil(registerRequest.RedirectURIs),
+		Scopes:         nonNil(registerRequest.Scopes),
+		GrantTypes:     registerRequest.GrantTypes,
+		OrganizationID: registerRequest.OrganizationID,
+	}
+	if principal.UserID != uuid.Nil {
+		client.CreatedBy = &principal.UserID
+	}
+
+	var secret string
+	if registerRequest.Confidential {
+		secret, client.SecretHash, err = auth.GenerateOpaqueToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to register client")
+			return
+		}
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	if client.OrganizationID != nil {
+		if _, err := repository.NewOrganizationRepository(db).GetOrganization(*client.OrganizationID); err != nil {
+			respondWithDomainError(w, err, "register client")
+			return
+		}
+	}
+
+	oauthRepo := repository.NewOAuthRepository(db)
+	client, err = oauthRepo.InsertClient(client)
+	if err != nil {
+		log.Printf("Failed to register OAuth client: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to register client")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, RegisterOAuthClientResponse{OAuthClient: client, ClientSecret: secret})
+}
+
+// GetOAuthClients lists every registered client
+func GetOAuthClients(w http.ResponseWriter, r *http.Request) {
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	oauthRepo := repository.NewOAuthRepository(db)
+	clients, err := oauthRepo.GetAllClients()
+	if err != nil {
+		log.Printf("Failed to fetch OAuth clients: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to get clients")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, clients)
+}
+
+// RevokeOAuthClient stops a client from obtaining new tokens and invalidates
+// the ones it already holds
+func RevokeOAuthClient(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	oauthRepo := repository.NewOAuthRepository(db)
+	revoked, err := oauthRepo.RevokeClient(clientID)
+	if err != nil {
+		log.Printf("Failed to revoke OAuth client %s: %s", clientID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke client")
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "Client not found with ID: "+clientID)
+		return
+	}
+	auth.RevokeClientTokens(clientID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Authorize handles both steps of the authorization endpoint for the signed-in
+// user. GET validates the request and either issues a code straight away, when
+// the user already consented to the scopes, or asks the front end to show a
+// consent screen. POST records the user's answer.
+func Authorize(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok || principal.AuthMethod != auth.AuthMethodJWT || principal.ClientID != "" || principal.IsImpersonated() {
+		respondWithError(w, http.StatusUnauthorized, "Sign in to authorize applications")
+		return
+	}
+
+	var authRequest AuthorizationRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&authRequest); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+	} else {
+		query := r.URL.Query()
+		if query.Get("response_type") != "code" {
+			respondWithError(w, http.StatusBadRequest, "response_type must be code")
+			return
+		}
+		authRequest = AuthorizationRequest{
+			ClientID:            query.Get("client_id"),
+			RedirectURI:         query.Get("redirect_uri"),
+			Scope:               query.Get("scope"),
+			State:               query.Get("state"),
+			CodeChallenge:       query.Get("code_challenge"),
+			CodeChallengeMethod: query.Get("code_challenge_method"),
+		}
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	// Until the client and redirect URI are validated errors go to the user, never to the redirect URI
+	oauthRepo := repository.NewOAuthRepository(db)
+	client, err := oauthRepo.GetClient(authRequest.ClientID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unknown client")
+		return
+	}
+	if !slices.Contains(client.GrantTypes, grantAuthorizationCode) {
+		respondWithError(w, http.StatusBadRequest, "Client may not use the authorization code grant")
+		return
+	}
+	if !slices.Contains(client.RedirectURIs, authRequest.RedirectURI) {
+		respondWithError(w, http.StatusBadRequest, "Redirect URI is not registered for this client")
+		return
+	}
+
+	if authRequest.CodeChallenge == "" || authRequest.CodeChallengeMethod != "S256" {
+		redirectWithError(w, authRequest, "invalid_request", "PKCE with S256 is required")
+		return
+	}
+	scopes, ok := requestedScopes(authRequest.Scope, client)
+	if !ok {
+		redirectWithError(w, authRequest, "invalid_scope", "Requested scope is not allowed for this client")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if !authRequest.Approve {
+			redirectWithError(w, authRequest, "access_denied", "The user denied the request")
+			return
+		}
+		if err := oauthRepo.SaveConsent(principal.UserID, client.ID, scopes); err != nil {
+			log.Printf("Failed to save consent of user %s for client %s: %s", principal.UserID, client.ID, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to authorize")
+			return
+		}
+	} else {
+		consent, err := oauthRepo.GetConsent(principal.UserID, client.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to fetch consent of user %s for client %s: %s", principal.UserID, client.ID, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to authorize")
+			return
+		}
+		if err != nil || !containsAll(consent.Scopes, scopes) {
+			respondWithJSON(w, http.StatusOK, AuthorizationResponse{
+				ConsentRequired: true,
+				ClientName:      client.Name,
+				Scopes:          scopes,
+			})
+			return
+		}
+	}
+
+	code, codeHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to authorize")
+		return
+	}
+	err = oauthRepo.InsertAuthorizationCode(models.OAuthAuthorizationCode{
+		CodeHash:      codeHash,
+		ClientID:      client.ID,
+		UserID:        principal.UserID,
+		RedirectURI:   authRequest.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: authRequest.CodeChallenge,
+		ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		log.Printf("Failed to store authorization code: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to authorize")
+		return
+	}
+
+	params := url.Values{}
+	params.Set("code", code)
+	if authRequest.State != "" {
+		params.Set("state", authRequest.State)
+	}
+	respondWithJSON(w, http.StatusOK, AuthorizationResponse{RedirectTo: appendQuery(authRequest.RedirectURI, params)})
+}
+
+// OAuthToken is the token endpoint for the authorization code and client credentials grants
+func OAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Malformed form body")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	oauthRepo := repository.NewOAuthRepository(db)
+	client, authenticated, err := authenticateClient(r, oauthRepo)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	if !slices.Contains(client.GrantTypes, grantType) {
+		respondWithOAuthError(w, http.StatusBadRequest, "unauthorized_client", "Client may not use this grant type")
+		return
+	}
+
+	switch grantType {
+	case grantAuthorizationCode:
+		// The code is only used up once the request proves it holds it
+		verifier := r.PostForm.Get("code_verifier")
+		code, err := oauthRepo.ConsumeAuthorizationCode(auth.HashToken(r.PostForm.Get("code")), func(code models.OAuthAuthorizationCode) error {
+			if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+				return errInvalidAuthorizationCode
+			}
+			if verifier == "" || subtle.ConstantTimeCompare([]byte(oidc.CodeChallenge(verifier)), []byte(code.CodeChallenge)) != 1 {
+				return errPKCEVerificationFailed
+			}
+			return nil
+		})
+		if errors.Is(err, errPKCEVerificationFailed) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "PKCE verification failed")
+			return
+		}
+		if errors.Is(err, errInvalidAuthorizationCode) || errors.Is(err, sql.ErrNoRows) {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to redeem authorization code for client %s: %s", client.ID, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		user, err := repository.NewUserRepository(db).GetUserByID(code.UserID)
+		if err != nil {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "User no longer exists")
+			return
+		}
+
+		tenant, err := defaultTenant(db, user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		token, err := auth.GenerateOAuthAccessToken(client.ID, user.ID, []string{user.Role}, tenant, code.Scopes)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		respondWithOAuthToken(w, token, code.Scopes)
+
+	case grantClientCredentials:
+		if !authenticated {
+			respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+			return
+		}
+		scopes, ok := requestedScopes(r.PostForm.Get("scope"), client)
+		if !ok {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_scope", "Requested scope is not allowed for this client")
+			return
+		}
+
+		// A client acting for itself only ever sees its own organization
+		if client.OrganizationID == nil {
+			respondWithOAuthError(w, http.StatusBadRequest, "unauthorized_client", "Client is not bound to an organization")
+			return
+		}
+		tenant := auth.Tenant{ID: *client.OrganizationID, Role: models.OrgRoleMember}
+
+		token, err := auth.GenerateOAuthAccessToken(client.ID, uuid.Nil, nil, tenant, scopes)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		respondWithOAuthToken(w, token, scopes)
+
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant type")
+	}
+}
+
+// IntrospectToken implements RFC 7662 for confidential clients
+func IntrospectToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Malformed form body")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	if _, authenticated, err := authenticateClient(r, repository.NewOAuthRepository(db)); err != nil || !authenticated {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	principal, err := auth.ParseAccessToken(r.PostForm.Get("token"))
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidToken) && !errors.Is(err, auth.ErrTokenExpired) && !errors.Is(err, auth.ErrTokenRevoked) {
+			log.Printf("Failed to introspect token: %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to introspect token")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+
+	response := IntrospectionResponse{
+		Active:    true,
+		ClientID:  principal.ClientID,
+		TokenType: "Bearer",
+		ExpiresAt: principal.ExpiresAt.Unix(),
+		IssuedAt:  principal.IssuedAt.Unix(),
+		TokenID:   principal.TokenID.String(),
+		Subject:   principal.UserID.String(),
+	}
+	if principal.IsClient() {
+		response.Subject = principal.ClientID
+	}
+	if principal.Scopes != nil {
+		response.Scope = strings.Join(principal.Scopes, " ")
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// RevokeOAuthToken implements RFC 7009. Clients may only revoke their own tokens,
+// and unknown tokens are not an error.
+func RevokeOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Malformed form body")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	client, _, err := authenticateClient(r, repository.NewOAuthRepository(db))
+	if err != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	principal, err := auth.ParseAccessToken(r.PostForm.Get("token"))
+	if err == nil && principal.ClientID == client.ID {
+		if err := auth.RevokeToken(principal); err != nil {
+			log.Printf("Failed to revoke token %s: %s", principal.TokenID, err)
+			respondWithOAuthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "Failed to revoke token")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetOAuthConsents lists the applications the caller has authorized
+func GetOAuthConsents(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	consents, err := repository.NewOAuthRepository(db).GetConsentsByUser(principal.UserID)
+	if err != nil {
+		log.Printf("Failed to fetch consents of user %s: %s", principal.UserID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to get consents")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, consents)
+}
+
+// RevokeOAuthConsent withdraws the caller's consent for an application
+func RevokeOAuthConsent(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	clientID := mux.Vars(r)["client_id"]
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	deleted, err := repository.NewOAuthRepository(db).DeleteConsent(principal.UserID, clientID)
+	if err != nil {
+		log.Printf("Failed to delete consent of user %s for client %s: %s", principal.UserID, clientID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke consent")
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "No consent found for client: "+clientID)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticateClient identifies the client from HTTP Basic auth or the form body.
+// The second return value is false for public clients, which only send their ID.
+func authenticateClient(r *http.Request, oauthRepo *repository.OAuthRepository) (models.OAuthClient, bool, error) {
+	clientID, secret, hasBasic := r.BasicAuth()
+	if hasBasic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := oauthRepo.GetClient(clientID)
+	if err != nil {
+		return models.OAuthClient{}, false, err
+	}
+
+	if !client.Confidential() {
+		if secret != "" {
+			return models.OAuthClient{}, false, errors.New("public client sent a secret")
+		}
+		return client, false, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return models.OAuthClient{}, false, errors.New("invalid client secret")
+	}
+	return client, true, nil
+}
+
+// requestedScopes parses a space separated scope parameter, defaulting to every
+// scope of the client, and reports whether all of them are allowed
+func requestedScopes(scope string, client models.OAuthClient) ([]string, bool) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return client.Scopes, true
+	}
+	return scopes, containsAll(client.Scopes, scopes)
+}
+
+func redirectWithError(w http.ResponseWriter, authRequest AuthorizationRequest, code, description string) {
+	params := url.Values{}
+	params.Set("error", code)
+	params.Set("error_description", description)
+	if authRequest.State != "" {
+		params.Set("state", authRequest.State)
+	}
+	respondWithJSON(w, http.StatusOK, AuthorizationResponse{RedirectTo: appendQuery(authRequest.RedirectURI, params)})
+}
+
+func respondWithOAuthToken(w http.ResponseWriter, token string, scopes []string) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, OAuthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   auth.OAuthAccessTokenTTLSeconds,
+		Scope:       strings.Join(scopes, " "),
+	})
+}
+
+// respondWithOAuthError writes an RFC 6749 error response
+func respondWithOAuthError(w http.ResponseWriter, code int, oauthError, description string) {
+	respondWithJSON(w, code, map[string]string{"error": oauthError, "error_description": description})
+}
+
+func appendQuery(rawURL string, params url.Values) string {
+	if strings.Contains(rawURL, "?") {
+		return rawURL + "&" + params.Encode()
+	}
+	return rawURL + "?" + params.Encode()
+}
+
+func randomClientID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "cli_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func containsAll(values, wanted []string) bool {
+	for _, w := range wanted {
+		if !slices.Contains(values, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
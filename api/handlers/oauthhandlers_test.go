@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/models"
+	"booking-service/oidc"
+	"booking-service/repository"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+const testRedirectURI = "https://partner.example.com/callback"
+
+// testOAuthClient is a registered client and, if confidential, its secret
+type testOAuthClient struct {
+	models.OAuthClient
+	Secret string
+}
+
+// insertTestOAuthClient registers a client allowed every grant and the
+// users.read and users.list scopes. Confidential clients get a secret.
+func insertTestOAuthClient(t *testing.T, conn *sql.DB, confidential bool, organizationID *uuid.UUID) testOAuthClient {
+	t.Helper()
+	id, err := randomClientID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := testOAuthClient{OAuthClient: models.OAuthClient{
+		ID:             id,
+		Name:           "Test partner",
+		RedirectURIs:   []string{testRedirectURI},
+		Scopes:         []string{auth.PermUsersRead, auth.PermUsersList},
+		GrantTypes:     []string{grantAuthorizationCode, grantClientCredentials},
+		OrganizationID: organizationID,
+	}}
+	if confidential {
+		client.Secret, client.SecretHash, err = auth.GenerateOpaqueToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.OAuthClient, err = repository.NewOAuthRepository(conn).InsertClient(client.OAuthClient); err != nil {
+		t.Fatalf("InsertClient: %s", err)
+	}
+	t.Cleanup(func() {
+		for _, statement := range []string{
+			`DELETE FROM public.oauth_authorization_code WHERE client_id = $1`,
+			`DELETE FROM public.oauth_consent WHERE client_id = $1`,
+			`DELETE FROM public.oauth_client WHERE id = $1`,
+		} {
+			if _, err := conn.Exec(statement, id); err != nil {
+				t.Errorf("cleaning up test client: %s", err)
+			}
+		}
+	})
+	return client
+}
+
+// insertTestOrganization creates an organization owned by a new admin
+func insertTestOrganization(t *testing.T, conn *sql.DB) models.Organization {
+	t.Helper()
+	owner := insertTestUser(t, conn, models.RoleAdmin)
+	org, err := repository.NewOrganizationRepository(conn).InsertOrganization(models.Organization{
+		Name: "Test organization",
+		Slug: "test-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12],
+	}, owner.ID)
+	if err != nil {
+		t.Fatalf("InsertOrganization: %s", err)
+	}
+	t.Cleanup(func() {
+		for _, statement := range []string{
+			`DELETE FROM public.organization_member WHERE organization_id = $1`,
+			`DELETE FROM public.organization WHERE id = $1`,
+		} {
+			if _, err := conn.Exec(statement, org.ID); err != nil {
+				t.Errorf("cleaning up test organization: %s", err)
+			}
+		}
+	})
+	return org
+}
+
+// oauthRequest posts the form to an OAuth endpoint, authenticating with HTTP
+// Basic when the client has a secret
+func oauthRequest(handler http.HandlerFunc, path string, client testOAuthClient, form url.Values) *httptest.ResponseRecorder {
+	if client.Secret == "" {
+		form.Set("client_id", client.ID)
+	}
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if client.Secret != "" {
+		r.SetBasicAuth(url.QueryEscape(client.ID), url.QueryEscape(client.Secret))
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestRequestedScopes(t *testing.T) {
+	client := models.OAuthClient{Scopes: []string{auth.PermUsersRead, auth.PermUsersList}}
+	tests := []struct {
+		scope  string
+		want   []string
+		wantOK bool
+	}{
+		{"", client.Scopes, true},
+		{"  ", client.Scopes, true},
+		{auth.PermUsersRead, []string{auth.PermUsersRead}, true},
+		{auth.PermUsersList + " " + auth.PermUsersRead, []string{auth.PermUsersList, auth.PermUsersRead}, true},
+		{auth.PermUsersRead + " " + auth.PermUsersUpdate, nil, false},
+		{"unknown", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := requestedScopes(tt.scope, client)
+		if ok != tt.wantOK || (ok && !slices.Equal(got, tt.want)) {
+			t.Errorf("requestedScopes(%q) = %v, %v, want %v, %v", tt.scope, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestAuthenticateClient(t *testing.T) {
+	conn := testDB(t)
+	oauthRepo := repository.NewOAuthRepository(conn)
+	confidential := insertTestOAuthClient(t, conn, true, nil)
+	public := insertTestOAuthClient(t, conn, false, nil)
+
+	request := func(basicID, basicSecret string, form url.Values) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if basicID != "" {
+			r.SetBasicAuth(url.QueryEscape(basicID), url.QueryEscape(basicSecret))
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name              string
+		r                 *http.Request
+		wantErr           bool
+		wantAuthenticated bool
+	}{
+		{"basic with secret", request(confidential.ID, confidential.Secret, url.Values{}), false, true},
+		{"form with secret", request("", "", url.Values{"client_id": {confidential.ID}, "client_secret": {confidential.Secret}}), false, true},
+		{"wrong secret", request(confidential.ID, "wrong", url.Values{}), true, false},
+		{"confidential without secret", request("", "", url.Values{"client_id": {confidential.ID}}), true, false},
+		{"public client", request("", "", url.Values{"client_id": {public.ID}}), false, false},
+		{"public client sending a secret", request(public.ID, "anything", url.Values{}), true, false},
+		{"unknown client", request("", "", url.Values{"client_id": {"cli_unknown"}}), true, false},
+	}
+	for _, tt := range tests {
+		client, authenticated, err := authenticateClient(tt.r, oauthRepo)
+		if (err != nil) != tt.wantErr || authenticated != tt.wantAuthenticated {
+			t.Errorf("%s: authenticateClient() = %v, %v, want error %v and authenticated %v", tt.name, authenticated, err, tt.wantErr, tt.wantAuthenticated)
+		}
+		if err == nil && client.ID == "" {
+			t.Errorf("%s: no client returned", tt.name)
+		}
+	}
+}
+
+func TestClientCredentialsGrantRoundTrip(t *testing.T) {
+	conn := testDB(t)
+	org := insertTestOrganization(t, conn)
+	client := insertTestOAuthClient(t, conn, true, &org.ID)
+
+	w := oauthRequest(OAuthToken, "/oauth/token", client, url.Values{"grant_type": {grantClientCredentials}, "scope": {auth.PermUsersRead}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("token status = %d, body %s", w.Code, w.Body)
+	}
+	var token OAuthTokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&token); err != nil {
+		t.Fatal(err)
+	}
+
+	principal, err := auth.ParseAccessToken(token.AccessToken)
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %s", err)
+	}
+	if !principal.IsClient() || principal.Tenant.ID != org.ID {
+		t.Errorf("principal = %+v, want client %s in organization %s", principal, client.ID, org.ID)
+	}
+
+	w = oauthRequest(IntrospectToken, "/oauth/introspect", client, url.Values{"token": {token.AccessToken}})
+	var introspection IntrospectionResponse
+	if err := json.NewDecoder(w.Body).Decode(&introspection); err != nil {
+		t.Fatal(err)
+	}
+	if !introspection.Active || introspection.Subject != client.ID || introspection.Scope != auth.PermUsersRead {
+		t.Errorf("introspection = %+v", introspection)
+	}
+}
+
+func TestClientCredentialsGrantRequiresOrganization(t *testing.T) {
+	conn := testDB(t)
+	client := insertTestOAuthClient(t, conn, true, nil)
+
+	w := oauthRequest(OAuthToken, "/oauth/token", client, url.Values{"grant_type": {grantClientCredentials}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("token status = %d, want %d; body %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+}
+
+func TestAuthorizationCodeGrantWithPKCE(t *testing.T) {
+	conn := testDB(t)
+	user := insertTestUser(t, conn, models.RoleCustomer)
+	client := insertTestOAuthClient(t, conn, false, nil)
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	body, _ := json.Marshal(AuthorizationRequest{
+		ClientID:            client.ID,
+		RedirectURI:         testRedirectURI,
+		Scope:               auth.PermUsersRead,
+		State:               "xyz",
+		CodeChallenge:       oidc.CodeChallenge(verifier),
+		CodeChallengeMethod: "S256",
+		Approve:             true,
+	})
+	r := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(string(body)))
+	r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{UserID: user.ID, Roles: []string{user.Role}, AuthMethod: auth.AuthMethodJWT}))
+	w := httptest.NewRecorder()
+	Authorize(w, r)
+	var authorization AuthorizationResponse
+	if err := json.NewDecoder(w.Body).Decode(&authorization); err != nil || authorization.RedirectTo == "" {
+		t.Fatalf("authorize status = %d, response %+v", w.Code, authorization)
+	}
+	redirect, err := url.Parse(authorization.RedirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := redirect.Query().Get("code")
+	if code == "" || redirect.Query().Get("state") != "xyz" {
+		t.Fatalf("redirect = %s", authorization.RedirectTo)
+	}
+
+	redeem := func(verifier string) *httptest.ResponseRecorder {
+		return oauthRequest(OAuthToken, "/oauth/token", client, url.Values{
+			"grant_type":    {grantAuthorizationCode},
+			"code":          {code},
+			"redirect_uri":  {testRedirectURI},
+			"code_verifier": {verifier},
+		})
+	}
+
+	// A wrong verifier must not use the code up
+	if w := redeem("wrong-verifier"); w.Code != http.StatusBadRequest {
+		t.Fatalf("wrong verifier status = %d, body %s", w.Code, w.Body)
+	}
+	w = redeem(verifier)
+	if w.Code != http.StatusOK {
+		t.Fatalf("token status = %d, body %s", w.Code, w.Body)
+	}
+	var token OAuthTokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&token); err != nil {
+		t.Fatal(err)
+	}
+	principal, err := auth.ParseAccessToken(token.AccessToken)
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %s", err)
+	}
+	if principal.UserID != user.ID || principal.ClientID != client.ID || !slices.Equal(principal.Scopes, []string{auth.PermUsersRead}) {
+		t.Errorf("principal = %+v", principal)
+	}
+
+	if w := redeem(verifier); w.Code != http.StatusBadRequest {
+		t.Errorf("second redemption status = %d, body %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/models"
+	"booking-service/oidc"
+	"booking-service/password"
+	"booking-service/repository"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// oidcLoginTTL is how long the user has to complete the login at the provider
+const oidcLoginTTL = 10 * time.Minute
+
+const oidcStateCookie = "oidc_state"
+
+// oidcProvider is nil unless an identity provider is configured
+var oidcProvider *oidc.Provider
+
+// SetOIDCProvider enables login through an external OpenID Connect provider
+func SetOIDCProvider(p *oidc.Provider) {
+	oidcProvider = p
+}
+
+type OIDCLinkResponse struct {
+	// AuthorizationURL is where the browser signs in at the provider to finish linking
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// errOIDCAccountExists is returned when an unlinked subject's email belongs to
+// a local account. Linking requires the account holder to sign in first.
+var errOIDCAccountExists = &repository.Error{Kind: repository.ErrConflict, Message: "an account with this email already exists; sign in and link the identity provider from your profile"}
+
+var errOIDCEmailUnverified = &repository.Error{Kind: repository.ErrForbidden, Message: "identity provider did not return a verified email"}
+
+// startOIDCFlow stores a new login state, binds it to the browser and returns
+// the provider URL to send the browser to. linkUserID is set when a signed-in
+// user is linking an identity rather than logging in.
+func startOIDCFlow(w http.ResponseWriter, r *http.Request, linkUserID uuid.UUID) (string, bool) {
+	state, errState := auth.RandomString()
+	nonce, errNonce := auth.RandomString()
+	verifier, errVerifier := auth.RandomString()
+	if errState != nil || errNonce != nil || errVerifier != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to start login")
+		return "", false
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return "", false
+	}
+	defer db.Close()
+
+	identityRepo := repository.NewIdentityRepository(db)
+	login := repository.OIDCLoginState{Nonce: nonce, CodeVerifier: verifier, LinkUserID: linkUserID}
+	if err := identityRepo.InsertLoginState(state, login, time.Now().Add(oidcLoginTTL)); err != nil {
+		log.Printf("Failed to store OIDC login state: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to start login")
+		return "", false
+	}
+
+	// Bind the state to this browser so a callback cannot be replayed into another session
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return oidcProvider.AuthCodeURL(state, nonce, oidc.CodeChallenge(verifier)), true
+}
+
+// OIDCLogin starts the authorization code flow by redirecting to the provider
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		respondWithError(w, http.StatusNotFound, "OIDC login is not configured")
+		return
+	}
+
+	authURL, ok := startOIDCFlow(w, r, uuid.Nil)
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// LinkOIDCIdentity starts linking the caller's account to their identity at
+// the provider. The browser is sent to the returned URL and the callback links
+// whichever subject signs in there.
+func LinkOIDCIdentity(w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		respondWithError(w, http.StatusNotFound, "OIDC login is not configured")
+		return
+	}
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok || principal.UserID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if principal.IsImpersonated() || principal.AuthMethod != auth.AuthMethodJWT {
+		respondWithError(w, http.StatusForbidden, "Linking an identity requires a direct session")
+		return
+	}
+
+	authURL, ok := startOIDCFlow(w, r, principal.UserID)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, OIDCLinkResponse{AuthorizationURL: authURL})
+}
+
+// OIDCCallback finishes the flow. A link started by a signed-in user links the
+// subject to them; a login signs in the linked or newly provisioned user,
+// asking for their second factor first if they have one.
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		respondWithError(w, http.StatusNotFound, "OIDC login is not configured")
+		return
+	}
+
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		respondWithError(w, http.StatusUnauthorized, "Login failed at identity provider: "+providerError)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Invalid login state")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/oidc", MaxAge: -1})
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	identityRepo := repository.NewIdentityRepository(db)
+	login, err := identityRepo.ConsumeLoginState(state)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid login state")
+		return
+	}
+
+	rawIDToken, err := oidcProvider.Exchange(query.Get("code"), login.CodeVerifier)
+	if err != nil {
+		log.Printf("Failed to exchange OIDC code: %s", err)
+		respondWithError(w, http.StatusUnauthorized, "Failed to complete login")
+		return
+	}
+	claims, err := oidcProvider.VerifyIDToken(rawIDToken, login.Nonce)
+	if err != nil {
+		log.Printf("Rejected OIDC ID token: %s", err)
+		respondWithError(w, http.StatusUnauthorized, "Failed to complete login")
+		return
+	}
+
+	if login.LinkUserID != uuid.Nil {
+		identity, err := linkOIDCIdentity(db, login.LinkUserID, claims)
+		if err != nil {
+			respondWithDomainError(w, err, "link identity")
+			return
+		}
+		log.Printf("User %s linked %s subject %s", identity.UserID, identity.Provider, identity.Subject)
+		respondWithJSON(w, http.StatusOK, identity)
+		return
+	}
+
+	user, err := findOIDCUser(db, claims)
+	if err != nil {
+		respondWithDomainError(w, err, "complete login")
+		return
+	}
+
+	// The provider replaces the password, not the second factor
+	challenge, err := mfaChallenge(repository.NewMFARepository(db), user.ID)
+	if err != nil {
+		log.Printf("Failed to check MFA for user %s: %s", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to complete login")
+		return
+	}
+	if challenge != nil {
+		respondWithJSON(w, http.StatusOK, challenge)
+		return
+	}
+
+	tokens, err := issueSessionTokens(db, user)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+// findOIDCUser returns the local user linked to the external subject. An
+// unknown subject gets a new user provisioned, unless a local account already
+// has its email: that account's holder must link it explicitly.
+func findOIDCUser(conn *sql.DB, claims oidc.Claims) (models.User, error) {
+	provider := oidcProvider.Config.Name
+	identityRepo := repository.NewIdentityRepository(conn)
+	userRepo := repository.NewUserRepository(conn)
+
+	identity, err := identityRepo.GetIdentity(provider, claims.Subject)
+	if err == nil {
+		if err := identityRepo.TouchIdentity(identity.ID, claims.Email); err != nil {
+			log.Printf("Failed to update identity %s: %s", identity.ID, err)
+		}
+		return userRepo.GetUserByID(identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return models.User{}, errOIDCEmailUnverified
+	}
+
+	_, err = userRepo.GetUserByEmail(claims.Email)
+	if err == nil {
+		return models.User{}, errOIDCAccountExists
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return models.User{}, err
+	}
+
+	user, err := provisionOIDCUser(userRepo, claims)
+	if errors.Is(err, repository.ErrDuplicateUsername) {
+		return models.User{}, errOIDCAccountExists
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	if _, err := identityRepo.InsertIdentity(user.ID, provider, claims.Subject, claims.Email); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// linkOIDCIdentity links the external subject to the user who started the
+// link. Linking a subject already linked to the same user is a no-op.
+func linkOIDCIdentity(conn *sql.DB, userID uuid.UUID, claims oidc.Claims) (models.UserIdentity, error) {
+	provider := oidcProvider.Config.Name
+	identityRepo := repository.NewIdentityRepository(conn)
+
+	if _, err := repository.NewUserRepository(conn).GetUserByID(userID); err != nil {
+		return models.UserIdentity{}, err
+	}
+
+	identity, err := identityRepo.GetIdentity(provider, claims.Subject)
+	if err == nil {
+		if identity.UserID != userID {
+			return models.UserIdentity{}, repository.ErrIdentityLinked
+		}
+		return identity, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return models.UserIdentity{}, err
+	}
+	return identityRepo.InsertIdentity(userID, provider, claims.Subject, claims.Email)
+}
+
+func provisionOIDCUser(userRepo *repository.UserRepository, claims oidc.Claims) (models.User, error) {
+	role := oidcProvider.Config.DefaultRole
+	if role == "" {
+		role = models.RoleCustomer
+	}
+
+	// The user signs in through the provider until they reset a password
+	user, err := userRepo.InsertUser(models.User{
+		FirstName: claims.GivenName,
+		LastName:  claims.FamilyName,
+		Username:  strings.ToLower(claims.Email),
+		Password:  password.Unusable,
+		Role:      role,
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	log.Printf("Provisioned user %s for %s subject %s", user.ID, oidcProvider.Config.Name, claims.Subject)
+
+	// The provider already verified the address
+	if err := userRepo.MarkEmailVerified(user.ID, user.Username); err != nil {
+		log.Printf("Failed to mark email verified for user %s: %s", user.ID, err)
+	}
+	return user, nil
+}
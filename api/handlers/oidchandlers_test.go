@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/models"
+	"booking-service/oidc"
+	"booking-service/oidc/oidctest"
+	"booking-service/repository"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// stubOIDCProvider points the handlers at a stub identity provider for the
+// duration of the test
+func stubOIDCProvider(t *testing.T) *oidctest.Server {
+	t.Helper()
+	idp := oidctest.NewServer()
+	t.Cleanup(idp.Close)
+
+	provider, err := oidc.Discover(oidc.Config{
+		Name:        "stub-" + uuid.NewString(),
+		Issuer:      idp.Issuer(),
+		ClientID:    "booking-service",
+		RedirectURL: "http://localhost:8080/oidc/callback",
+		DefaultRole: models.RoleCustomer,
+	})
+	if err != nil {
+		t.Fatalf("Discover: %s", err)
+	}
+	SetOIDCProvider(provider)
+	t.Cleanup(func() { SetOIDCProvider(nil) })
+	return idp
+}
+
+// finishOIDCFlow signs in at the stub provider as identity and calls the
+// callback from the browser that started the flow
+func finishOIDCFlow(t *testing.T, idp *oidctest.Server, start *httptest.ResponseRecorder, authURL string, identity oidctest.Identity) *httptest.ResponseRecorder {
+	t.Helper()
+	code, state, err := idp.Authorize(authURL, identity)
+	if err != nil {
+		t.Fatalf("Authorize: %s", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	for _, cookie := range start.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	OIDCCallback(w, r)
+	return w
+}
+
+// oidcLogin runs a whole login through the stub provider
+func oidcLogin(t *testing.T, idp *oidctest.Server, identity oidctest.Identity) *httptest.ResponseRecorder {
+	t.Helper()
+	start := httptest.NewRecorder()
+	OIDCLogin(start, httptest.NewRequest(http.MethodGet, "/oidc/login", nil))
+	if start.Code != http.StatusFound {
+		t.Fatalf("OIDCLogin status = %d, body %s", start.Code, start.Body)
+	}
+	return finishOIDCFlow(t, idp, start, start.Header().Get("Location"), identity)
+}
+
+// oidcLink runs a whole identity link for the signed-in user
+func oidcLink(t *testing.T, idp *oidctest.Server, userID uuid.UUID, identity oidctest.Identity) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/me/identities/oidc", nil)
+	r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{UserID: userID, AuthMethod: auth.AuthMethodJWT}))
+	start := httptest.NewRecorder()
+	LinkOIDCIdentity(start, r)
+	if start.Code != http.StatusOK {
+		t.Fatalf("LinkOIDCIdentity status = %d, body %s", start.Code, start.Body)
+	}
+	var link OIDCLinkResponse
+	if err := json.NewDecoder(start.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+	return finishOIDCFlow(t, idp, start, link.AuthorizationURL, identity)
+}
+
+func insertTestUser(t *testing.T, conn *sql.DB, role string) models.User {
+	t.Helper()
+	user, err := repository.NewUserRepository(conn).InsertUser(models.User{
+		FirstName: "Local",
+		LastName:  "User",
+		Username:  testEmail(role),
+		Password:  "correct horse battery",
+		Role:      role,
+	})
+	if err != nil {
+		t.Fatalf("InsertUser: %s", err)
+	}
+	t.Cleanup(func() { deleteTestUsers(t, conn, user.ID) })
+	return user
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	conn := testDB(t)
+	idp := stubOIDCProvider(t)
+	identity := oidctest.Identity{Subject: uuid.NewString(), Email: testEmail("oidc"), EmailVerified: true, GivenName: "Olive", FamilyName: "Idp"}
+
+	w := oidcLogin(t, idp, identity)
+	user, err := repository.NewUserRepository(conn).GetUserByEmail(identity.Email)
+	if err != nil {
+		t.Fatalf("provisioned user not found: %s; callback status %d, body %s", err, w.Code, w.Body)
+	}
+	t.Cleanup(func() { deleteTestUsers(t, conn, user.ID) })
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback status = %d, body %s", w.Code, w.Body)
+	}
+	var tokens TokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&tokens); err != nil || tokens.Token == "" {
+		t.Fatalf("expected tokens, got %s", w.Body)
+	}
+
+	if user.Role != models.RoleCustomer || user.FirstName != "Olive" || user.EmailVerifiedAt == nil {
+		t.Errorf("provisioned user = %+v", user)
+	}
+	linked, err := repository.NewIdentityRepository(conn).GetIdentity(oidcProvider.Config.Name, identity.Subject)
+	if err != nil || linked.UserID != user.ID {
+		t.Fatalf("identity not linked to the provisioned user: %+v, %v", linked, err)
+	}
+
+	// Signing in again finds the same user
+	if w := oidcLogin(t, idp, identity); w.Code != http.StatusOK {
+		t.Fatalf("second login status = %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestOIDCLoginRejectsUnverifiedEmail(t *testing.T) {
+	testDB(t)
+	idp := stubOIDCProvider(t)
+	identity := oidctest.Identity{Subject: uuid.NewString(), Email: testEmail("unverified"), EmailVerified: false}
+
+	if w := oidcLogin(t, idp, identity); w.Code != http.StatusForbidden {
+		t.Fatalf("callback status = %d, want 403; body %s", w.Code, w.Body)
+	}
+}
+
+func TestOIDCLoginDoesNotLinkExistingAccount(t *testing.T) {
+	conn := testDB(t)
+	idp := stubOIDCProvider(t)
+	admin := insertTestUser(t, conn, models.RoleAdmin)
+	identity := oidctest.Identity{Subject: uuid.NewString(), Email: admin.Username, EmailVerified: true}
+
+	if w := oidcLogin(t, idp, identity); w.Code != http.StatusConflict {
+		t.Fatalf("callback status = %d, want 409; body %s", w.Code, w.Body)
+	}
+	_, err := repository.NewIdentityRepository(conn).GetIdentity(oidcProvider.Config.Name, identity.Subject)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("identity was linked to the existing account: %v", err)
+	}
+}
+
+func TestOIDCLinkThenLoginRequiresMFA(t *testing.T) {
+	conn := testDB(t)
+	idp := stubOIDCProvider(t)
+	user := insertTestUser(t, conn, models.RoleAdmin)
+	if _, err := conn.Exec(`INSERT INTO public.user_mfa (user_id, secret, enabled_at) VALUES ($1, 'JBSWY3DPEHPK3PXP', NOW())`, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	identity := oidctest.Identity{Subject: uuid.NewString(), Email: testEmail("corporate"), EmailVerified: true}
+
+	w := oidcLink(t, idp, user.ID, identity)
+	if w.Code != http.StatusOK {
+		t.Fatalf("link callback status = %d, body %s", w.Code, w.Body)
+	}
+	var linked models.UserIdentity
+	if err := json.NewDecoder(w.Body).Decode(&linked); err != nil || linked.UserID != user.ID || linked.Subject != identity.Subject {
+		t.Fatalf("linked identity = %+v, %v", linked, err)
+	}
+
+	// The provider stands in for the password only
+	w = oidcLogin(t, idp, identity)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login status = %d, body %s", w.Code, w.Body)
+	}
+	var challenge MFAChallengeResponse
+	if err := json.NewDecoder(w.Body).Decode(&challenge); err != nil || !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("expected an MFA challenge, got %s", w.Body)
+	}
+}
+
+func TestOIDCLinkRejectsSubjectOfAnotherUser(t *testing.T) {
+	conn := testDB(t)
+	idp := stubOIDCProvider(t)
+	first := insertTestUser(t, conn, models.RoleCustomer)
+	second := insertTestUser(t, conn, models.RoleCustomer)
+	identity := oidctest.Identity{Subject: uuid.NewString(), Email: testEmail("shared"), EmailVerified: true}
+
+	if w := oidcLink(t, idp, first.ID, identity); w.Code != http.StatusOK {
+		t.Fatalf("first link status = %d, body %s", w.Code, w.Body)
+	}
+	if w := oidcLink(t, idp, second.ID, identity); w.Code != http.StatusConflict {
+		t.Fatalf("second link status = %d, want 409; body %s", w.Code, w.Body)
+	}
+}
+
+func TestOIDCLinkRequiresDirectSession(t *testing.T) {
+	stubOIDCProvider(t)
+	r := httptest.NewRequest(http.MethodPost, "/me/identities/oidc", nil)
+	r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{
+		UserID:     uuid.New(),
+		AuthMethod: auth.AuthMethodJWT,
+		Actor:      &auth.Actor{UserID: uuid.New()},
+	}))
+	w := httptest.NewRecorder()
+	LinkOIDCIdentity(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", w.Code)
+	}
+}
+
+func TestOIDCCallbackRejectsStateFromAnotherBrowser(t *testing.T) {
+	idp := stubOIDCProvider(t)
+	code, state, err := idp.Authorize(oidcProvider.AuthCodeURL("state-1", "nonce", oidc.CodeChallenge("verifier")), oidctest.Identity{Subject: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	r.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "state-2"})
+	w := httptest.NewRecorder()
+	OIDCCallback(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+}
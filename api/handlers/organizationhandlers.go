@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/models"
+	"booking-service/repository"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+)
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+	// OwnerID optionally names an existing user to become the first owner
+	OwnerID uuid.UUID `json:"owner_id"`
+}
+
+type OrganizationMemberRequest struct {
+	UserID uuid.UUID `json:"user_id"`
+	Role   string    `json:"role"`
+}
+
+type SwitchOrganizationRequest struct {
+	// OrganizationID is the organization to act in; omit it to act in none
+	OrganizationID uuid.UUID `json:"organization_id"`
+}
+
+// errAdminRoleForbidden is returned when someone other than an admin creates,
+// changes or removes an admin
+var errAdminRoleForbidden = &repository.Error{Kind: repository.ErrForbidden, Message: "only admins can manage admin users"}
+
+// errSharedUserForbidden is returned when an organization changes the account
+// of a user who also belongs to other organizations
+var errSharedUserForbidden = &repository.Error{Kind: repository.ErrForbidden, Message: "user also belongs to other organizations; remove them from this organization instead"}
+
+var errOwnerRoleForbidden = &repository.Error{Kind: repository.ErrForbidden, Message: "only owners can manage organization owners"}
+
+// scopedUserRepository returns a user repository limited to the organization
+// the request's session acts in. Sessions outside any organization see every user.
+func scopedUserRepository(conn *sql.DB, r *http.Request) *repository.UserRepository {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	return repository.NewUserRepository(conn).ForTenant(principal.Tenant.ID)
+}
+
+// canManageRole reports whether the principal may create, change or remove
+// users holding the role. Organization admins hold the user permissions, so
+// without this they could make themselves, or lock out, platform admins.
+func canManageRole(principal auth.Principal, role string) bool {
+	return role != models.RoleAdmin || principal.HasRole(models.RoleAdmin)
+}
+
+// authorizeAccountChange checks that the principal may change the user's
+// account, such as their username or role, or delete it, rather than only
+// their membership. Within an organization that is allowed only for users who
+// belong to no other organization, and for the caller themselves.
+func authorizeAccountChange(userRepo *repository.UserRepository, principal auth.Principal, user models.User) error {
+	if !principal.Tenant.IsSet() || principal.UserID == user.ID {
+		return nil
+	}
+	shared, err := userRepo.IsSharedUser(user.ID)
+	if err != nil {
+		return err
+	}
+	if shared {
+		return errSharedUserForbidden
+	}
+	return nil
+}
+
+// defaultTenant returns the organization a new session of the user acts in:
+// the first one they joined, or none
+func defaultTenant(conn *sql.DB, userID uuid.UUID) (auth.Tenant, error) {
+	memberships, err := repository.NewOrganizationRepository(conn).GetMembershipsByUser(userID)
+	if err != nil || len(memberships) == 0 {
+		return auth.Tenant{}, err
+	}
+	return auth.Tenant{ID: memberships[0].OrganizationID, Role: memberships[0].Role}, nil
+}
+
+// canManageMembers reports whether the principal may manage the members of
+// the organization: anyone who manages organizations, or a member managing
+// their own organization
+func canManageMembers(principal auth.Principal, orgID uuid.UUID) bool {
+	if principal.Can(auth.PermOrganizationsManage) {
+		return true
+	}
+	return principal.Tenant.ID == orgID && principal.Can(auth.PermOrgMembersManage)
+}
+
+// CreateOrganization creates an organization, optionally with a first owner
+func CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var createRequest CreateOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&createRequest); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	org, err := repository.NewOrganizationRepository(db).InsertOrganization(models.Organization{
+		Name: createRequest.Name,
+		Slug: createRequest.Slug,
+	}, createRequest.OwnerID)
+	if err != nil {
+		respondWithDomainError(w, err, "create organization")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, org)
+}
+
+func GetOrganizations(w http.ResponseWriter, r *http.Request) {
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	orgs, err := repository.NewOrganizationRepository(db).GetOrganizations()
+	if err != nil {
+		respondWithDomainError(w, err, "get organizations")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, orgs)
+}
+
+func GetOrganizationMembers(w http.ResponseWriter, r *http.Request) {
+	orgID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !canManageMembers(principal, orgID) {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	orgRepo := repository.NewOrganizationRepository(db)
+	if _, err := orgRepo.GetOrganization(orgID); err != nil {
+		respondWithDomainError(w, err, "get organization members")
+		return
+	}
+	members, err := orgRepo.GetMembers(orgID)
+	if err != nil {
+		respondWithDomainError(w, err, "get organization members")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// AddOrganizationMember adds a user to the organization or changes a member's
+// role. Members managing their own organization can only change the roles of
+// existing members, and only owners can grant or take away the owner role.
+func AddOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	orgID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !canManageMembers(principal, orgID) {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	var memberRequest OrganizationMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&memberRequest); err != nil || memberRequest.UserID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	orgRepo := repository.NewOrganizationRepository(db)
+	if !principal.Can(auth.PermOrganizationsManage) {
+		existing, err := orgRepo.GetMembership(orgID, memberRequest.UserID)
+		if err != nil {
+			respondWithDomainError(w, err, "add organization member")
+			return
+		}
+		if principal.Tenant.Role != models.OrgRoleOwner && (existing.Role == models.OrgRoleOwner || memberRequest.Role == models.OrgRoleOwner) {
+			respondWithDomainError(w, errOwnerRoleForbidden, "add organization member")
+			return
+		}
+	}
+
+	member, err := orgRepo.AddMember(models.OrganizationMember{
+		OrganizationID: orgID,
+		UserID:         memberRequest.UserID,
+		Role:           memberRequest.Role,
+	})
+	if err != nil {
+		respondWithDomainError(w, err, "add organization member")
+		return
+	}
+
+	log.Printf("User %s set role of %s in organization %s to %s", principal.UserID, member.UserID, orgID, member.Role)
+	respondWithJSON(w, http.StatusOK, member)
+}
+
+func RemoveOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	userID, err := uuid.Parse(vars["user_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !canManageMembers(principal, orgID) {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	orgRepo := repository.NewOrganizationRepository(db)
+	if !principal.Can(auth.PermOrganizationsManage) && principal.Tenant.Role != models.OrgRoleOwner {
+		existing, err := orgRepo.GetMembership(orgID, userID)
+		if err != nil {
+			respondWithDomainError(w, err, "remove organization member")
+			return
+		}
+		if existing.Role == models.OrgRoleOwner {
+			respondWithDomainError(w, errOwnerRoleForbidden, "remove organization member")
+			return
+		}
+	}
+
+	if err := orgRepo.RemoveMember(orgID, userID); err != nil {
+		respondWithDomainError(w, err, "remove organization member")
+		return
+	}
+
+	log.Printf("User %s removed %s from organization %s", principal.UserID, userID, orgID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMyOrganizations lists the organizations the caller belongs to
+func GetMyOrganizations(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok || principal.UserID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	memberships, err := repository.NewOrganizationRepository(db).GetMembershipsByUser(principal.UserID)
+	if err != nil {
+		respondWithDomainError(w, err, "get organizations")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, memberships)
+}
+
+// SwitchOrganization starts a new session for the caller acting in another
+// organization they belong to, or in none
+func SwitchOrganization(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok || principal.UserID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if principal.IsImpersonated() || principal.AuthMethod != auth.AuthMethodJWT {
+		respondWithError(w, http.StatusForbidden, "Switching organization requires a direct session")
+		return
+	}
+
+	var switchRequest SwitchOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&switchRequest); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	user, err := repository.NewUserRepository(db).GetUserByID(principal.UserID)
+	if err != nil {
+		respondWithDomainError(w, err, "switch organization")
+		return
+	}
+
+	var tenant auth.Tenant
+	if switchRequest.OrganizationID != uuid.Nil {
+		member, err := repository.NewOrganizationRepository(db).GetMembership(switchRequest.OrganizationID, user.ID)
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithError(w, http.StatusForbidden, "Not a member of the organization")
+			return
+		}
+		if err != nil {
+			respondWithDomainError(w, err, "switch organization")
+			return
+		}
+		tenant = auth.Tenant{ID: member.OrganizationID, Role: member.Role}
+	}
+
+	tokens, err := issueTokens(db, user, tenant, uuid.New())
+	if err != nil {
+		log.Printf("Failed to issue tokens for user %s: %s", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tokens)
+}
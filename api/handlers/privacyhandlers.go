@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"archive/zip"
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/models"
+	"booking-service/repository"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// UserExport is everything stored about a user, as returned by GET /users/{id}/export.
+// An export made by an organization only holds what belongs to it: the
+// account-wide sections, from identities to user tokens, are left empty.
+type UserExport struct {
+	ExportedAt     time.Time                     `json:"exported_at"`
+	User           UserResponse                  `json:"user"`
+	Identities     []models.UserIdentity         `json:"identities"`
+	APIKeys        []models.APIKey               `json:"api_keys"`
+	Sessions       []models.RefreshToken         `json:"sessions"`
+	Organizations  []models.OrganizationMember   `json:"organizations"`
+	Invitations    []models.Invitation           `json:"invitations"`
+	Groups         []models.Group                `json:"groups"`
+	MFA            *models.UserMFA               `json:"mfa"`
+	OAuthConsents  []models.OAuthConsent         `json:"oauth_consents"`
+	Impersonations []models.ImpersonationSession `json:"impersonations"`
+	Erasures       []models.UserErasure          `json:"erasures"`
+	LoginAttempt   *models.LoginAttempt          `json:"login_attempt"`
+	UserTokens     []models.UserToken            `json:"user_tokens"`
+}
+
+type EraseUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ExportUser answers a subject access request with everything stored about
+// the user as JSON, or with ?format=zip as a zip archive of one JSON file per
+// section. Secrets such as password and token hashes are never included.
+func ExportUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		respondWithError(w, http.StatusBadRequest, "format must be json or zip")
+		return
+	}
+
+	// Users exporting their own data get all of it; an organization gets its share
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	tenantID := principal.Tenant.ID
+	if principal.UserID == userID {
+		tenantID = uuid.Nil
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	export, err := buildUserExport(db, scopedUserRepository(db, r), userID, tenantID)
+	if err != nil {
+		respondWithDomainError(w, err, "export user")
+		return
+	}
+
+	filename := "user-" + userID.String()
+	if format != "zip" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		respondWithJSON(w, http.StatusOK, export)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	w.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(w)
+	sections := []struct {
+		name string
+		data interface{}
+	}{
+		{"user.json", export.User},
+		{"identities.json", export.Identities},
+		{"api_keys.json", export.APIKeys},
+		{"sessions.json", export.Sessions},
+		{"organizations.json", export.Organizations},
+		{"invitations.json", export.Invitations},
+		{"groups.json", export.Groups},
+		{"mfa.json", export.MFA},
+		{"oauth_consents.json", export.OAuthConsents},
+		{"impersonations.json", export.Impersonations},
+		{"erasures.json", export.Erasures},
+		{"login_attempt.json", export.LoginAttempt},
+		{"user_tokens.json", export.UserTokens},
+	}
+	for _, section := range sections {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: section.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err == nil {
+			enc := json.NewEncoder(f)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(section.data)
+		}
+		if err != nil {
+			log.Printf("Failed to write export of user %s: %s", userID, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to write export of user %s: %s", userID, err)
+	}
+}
+
+// buildUserExport gathers the user's data from every repository that holds
+// some, provided userRepo can see the user. With a tenant only the
+// organization's share is gathered.
+func buildUserExport(conn *sql.DB, userRepo *repository.UserRepository, userID, tenantID uuid.UUID) (UserExport, error) {
+	export := UserExport{ExportedAt: time.Now().UTC()}
+
+	user, err := userRepo.GetUserByIDIncludingDeleted(userID)
+	if err != nil {
+		return export, err
+	}
+	export.User = toUserResponse(user)
+
+	if export.APIKeys, err = repository.NewAPIKeyRepository(conn).ForTenant(tenantID).GetAPIKeysByUser(userID); err != nil {
+		return export, err
+	}
+	if export.Sessions, err = repository.NewRefreshTokenRepository(conn).ForTenant(tenantID).GetRefreshTokensByUser(userID); err != nil {
+		return export, err
+	}
+	if export.Organizations, err = repository.NewOrganizationRepository(conn).ForTenant(tenantID).GetMembershipsByUser(userID); err != nil {
+		return export, err
+	}
+	if export.Invitations, err = repository.NewInvitationRepository(conn).ForTenant(tenantID).GetInvitationsByUser(userID, user.Username); err != nil {
+		return export, err
+	}
+	if export.Groups, err = repository.NewGroupRepository(conn).ForTenant(tenantID).GetUserGroups(userID); err != nil {
+		return export, err
+	}
+	if export.OAuthConsents, err = repository.NewOAuthRepository(conn).ForTenant(tenantID).GetConsentsByUser(userID); err != nil {
+		return export, err
+	}
+	if export.Impersonations, err = repository.NewImpersonationRepository(conn).ForTenant(tenantID).GetSessions(&userID); err != nil {
+		return export, err
+	}
+
+	// The rest belongs to the account rather than to any organization
+	if tenantID != uuid.Nil {
+		export.Identities = []models.UserIdentity{}
+		export.Erasures = []models.UserErasure{}
+		export.UserTokens = []models.UserToken{}
+		return export, nil
+	}
+	if export.Identities, err = repository.NewIdentityRepository(conn).GetIdentitiesByUser(userID); err != nil {
+		return export, err
+	}
+	mfa, err := repository.NewMFARepository(conn).GetMFA(userID)
+	if err == nil {
+		export.MFA = &mfa
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return export, err
+	}
+	if export.Erasures, err = repository.NewErasureRepository(conn).GetErasures(userID); err != nil {
+		return export, err
+	}
+	attempt, err := repository.NewLoginAttemptRepository(conn).GetAttempt(auth.LockoutAccount, strings.ToLower(user.Username))
+	if err == nil {
+		export.LoginAttempt = &attempt
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return export, err
+	}
+	if export.UserTokens, err = repository.NewUserTokenRepository(conn).GetTokensByUser(userID); err != nil {
+		return export, err
+	}
+
+	return export, nil
+}
+
+// EraseUser carries out a right-to-erasure request: the user's personal data
+// is pseudonymized, their credentials and sessions are destroyed, and an
+// erasure record is kept. Erasure cannot be undone.
+func EraseUser(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if principal.IsImpersonated() {
+		respondWithError(w, http.StatusForbidden, "Erasure requires a direct admin session")
+		return
+	}
+
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+
+	var eraseRequest EraseUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&eraseRequest); err != nil || strings.TrimSpace(eraseRequest.Reason) == "" {
+		respondWithError(w, http.StatusBadRequest, "A reason is required")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	// Sessions bound to an organization may only erase its members
+	if _, err := scopedUserRepository(db, r).GetUserByIDIncludingDeleted(userID); err != nil {
+		respondWithDomainError(w, err, "erase user")
+		return
+	}
+
+	erasure := models.UserErasure{UserID: userID, Reason: strings.TrimSpace(eraseRequest.Reason)}
+	if principal.UserID != uuid.Nil {
+		erasure.RequestedBy = &principal.UserID
+	}
+	erasure, err = repository.NewErasureRepository(db).EraseUser(erasure)
+	if err != nil {
+		respondWithDomainError(w, err, "erase user")
+		return
+	}
+
+	// Access tokens issued before the erasure stop working immediately
+	if err := auth.RevokeUserTokens(userID); err != nil {
+		log.Printf("Failed to revoke tokens of erased user %s: %s", userID, err)
+	}
+
+	respondWithJSON(w, http.StatusOK, erasure)
+}
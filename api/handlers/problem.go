@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"booking-service/repository"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Problem is an RFC 7807 problem details body
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Errors holds per-field validation problems
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// respondWithProblem writes p as application/problem+json
+func respondWithProblem(w http.ResponseWriter, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	response, _ := json.Marshal(p)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(response)
+}
+
+// respondWithDomainError maps an error returned by a repository to a problem
+// response. Errors that are not domain errors are logged as failures to
+// perform action and reported as 500s without their details.
+func respondWithDomainError(w http.ResponseWriter, err error, action string) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, repository.ErrConflict):
+		status = http.StatusConflict
+	case errors.Is(err, repository.ErrValidation):
+		status = http.StatusBadRequest
+	case errors.Is(err, repository.ErrForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, repository.ErrPreconditionFailed):
+		status = http.StatusPreconditionFailed
+	}
+
+	if status == http.StatusInternalServerError {
+		log.Printf("Failed to %s: %s", action, err)
+		respondWithProblem(w, Problem{Status: status, Detail: "Failed to " + action})
+		return
+	}
+
+	problem := Problem{Status: status, Detail: err.Error()}
+	var domainErr *repository.Error
+	if errors.As(err, &domainErr) {
+		problem.Errors = domainErr.Fields
+	}
+	respondWithProblem(w, problem)
+}
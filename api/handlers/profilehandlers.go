@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/models"
+	"booking-service/password"
+	"booking-service/repository"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type RegisterRequest struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+type ProfileUpdateRequest struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+}
+
+// Register lets a customer create their own account. The role is always the
+// default customer role regardless of the payload.
+func Register(w http.ResponseWriter, r *http.Request) {
+	var registerRequest RegisterRequest
+	err := json.NewDecoder(r.Body).Decode(&registerRequest)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if strings.TrimSpace(registerRequest.Username) == "" || registerRequest.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Username and password are required")
+		return
+	}
+	if err := password.ValidatePolicy(registerRequest.Password); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Password must be at least 8 characters")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	userRepo := repository.NewUserRepository(db)
+	insertedUser, err := userRepo.InsertUser(models.User{
+		FirstName: registerRequest.FirstName,
+		LastName:  registerRequest.LastName,
+		Username:  strings.ToLower(registerRequest.Username),
+		Password:  registerRequest.Password,
+		Role:      models.RoleCustomer,
+	})
+	if err != nil {
+		respondWithDomainError(w, err, "register user")
+		return
+	}
+
+	if err := sendVerificationEmail(db, insertedUser); err != nil {
+		log.Printf("Failed to send verification email for user %s: %s", insertedUser.ID, err)
+	}
+
+	respondWithJSON(w, http.StatusCreated, toUserResponse(insertedUser))
+}
+
+// GetMe returns the profile of the authenticated user
+func GetMe(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	userRepo := repository.NewUserRepository(db)
+	user, err := userRepo.GetUserByID(principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, toUserResponse(user))
+}
+
+// UpdateMe updates the authenticated user's name and username; the role cannot be changed here
+func UpdateMe(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var updateRequest ProfileUpdateRequest
+	err := json.NewDecoder(r.Body).Decode(&updateRequest)
+	if err != nil || strings.TrimSpace(updateRequest.Username) == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	userRepo := repository.NewUserRepository(db)
+	existingUser, err := userRepo.GetUserByID(principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	updatedUser, err := userRepo.UpdateUser(models.User{
+		FirstName: updateRequest.FirstName,
+		LastName:  updateRequest.LastName,
+		Username:  updateRequest.Username,
+		Role:      existingUser.Role,
+		Version:   existingUser.Version,
+	}, existingUser.ID)
+	if err != nil {
+		respondWithDomainError(w, err, "update profile")
+		return
+	}
+	updatedUser.CreatedAt = existingUser.CreatedAt
+
+	// A changed address starts out unverified
+	if updatedUser.Username != existingUser.Username {
+		if err := sendVerificationEmail(db, updatedUser); err != nil {
+			log.Printf("Failed to send verification email for user %s: %s", updatedUser.ID, err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, toUserResponse(updatedUser))
+}
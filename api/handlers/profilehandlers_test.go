@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"booking-service/models"
+	"booking-service/repository"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// cleanupUsername deletes whichever users a race test managed to create
+func cleanupUsername(t *testing.T, conn *sql.DB, username string) {
+	t.Cleanup(func() {
+		rows, err := conn.Query(`SELECT id FROM public."user" WHERE lower(username) = lower($1)`, username)
+		if err != nil {
+			t.Errorf("finding test users: %s", err)
+			return
+		}
+		defer rows.Close()
+		var ids []uuid.UUID
+		for rows.Next() {
+			var id uuid.UUID
+			if err := rows.Scan(&id); err != nil {
+				t.Errorf("finding test users: %s", err)
+				return
+			}
+			ids = append(ids, id)
+		}
+		deleteTestUsers(t, conn, ids...)
+	})
+}
+
+// race runs each function at once and waits for all of them
+func race(fns ...func()) {
+	var ready, done sync.WaitGroup
+	start := make(chan struct{})
+	for _, fn := range fns {
+		ready.Add(1)
+		done.Add(1)
+		go func(fn func()) {
+			defer done.Done()
+			ready.Done()
+			<-start
+			fn()
+		}(fn)
+	}
+	ready.Wait()
+	close(start)
+	done.Wait()
+}
+
+func TestInsertUserConcurrentCaseInsensitiveDuplicate(t *testing.T) {
+	conn := testDB(t)
+	username := testEmail("race")
+	cleanupUsername(t, conn, username)
+
+	userRepo := repository.NewUserRepository(conn)
+	errs := make([]error, 2)
+	insert := func(i int, username string) func() {
+		return func() {
+			_, errs[i] = userRepo.InsertUser(models.User{
+				FirstName: "Race",
+				LastName:  "User",
+				Username:  username,
+				Password:  "correct horse battery",
+				Role:      models.RoleCustomer,
+			})
+		}
+	}
+	race(insert(0, strings.ToUpper(username)), insert(1, username))
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, repository.ErrDuplicateUsername):
+			t.Errorf("InsertUser error = %v, want ErrDuplicateUsername", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d inserts succeeded, want exactly 1; errors %v", succeeded, errs)
+	}
+}
+
+func TestRegisterConcurrentCaseInsensitiveDuplicate(t *testing.T) {
+	conn := testDB(t)
+	username := testEmail("race")
+	cleanupUsername(t, conn, username)
+
+	codes := make([]int, 2)
+	register := func(i int, username string) func() {
+		return func() {
+			body, _ := json.Marshal(RegisterRequest{FirstName: "Race", LastName: "User", Username: username, Password: "correct horse battery"})
+			w := httptest.NewRecorder()
+			Register(w, httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(body)))
+			codes[i] = w.Code
+		}
+	}
+	race(register(0, strings.ToUpper(username)), register(1, username))
+
+	created, conflicts := 0, 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+			conflicts++
+		}
+	}
+	if created != 1 || conflicts != 1 {
+		t.Fatalf("statuses = %v, want one 201 and one 409", codes)
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/mail"
+	"booking-service/models"
+	"booking-service/password"
+	"booking-service/repository"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+)
+
+// mailer sends password reset and verification emails
+var mailer mail.Mailer = mail.NewMemoryMailer()
+
+// SetMailer configures how the handlers send email
+func SetMailer(m mail.Mailer) {
+	mailer = m
+}
+
+// appURL builds a link into the front end, which is at APP_BASE_URL
+func appURL(path, token string) string {
+	base := os.Getenv("APP_BASE_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return base + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendUserToken stores a new single-use token for the user and emails it
+func sendUserToken(conn *sql.DB, user models.User, purpose string, ttl time.Duration, path string, msg func(link string) mail.Message) error {
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	tokenRepo := repository.NewUserTokenRepository(conn)
+	if err := tokenRepo.ReplaceToken(user.ID, purpose, user.Username, tokenHash, time.Now().Add(ttl)); err != nil {
+		return err
+	}
+
+	return mailer.Send(msg(appURL(path, token)))
+}
+
+// sendVerificationEmail emails the user a link proving they own their username's mailbox
+func sendVerificationEmail(conn *sql.DB, user models.User) error {
+	return sendUserToken(conn, user, repository.TokenPurposeEmailVerification, emailVerificationTTL, "/email/verify", func(link string) mail.Message {
+		return mail.Message{
+			To:      user.Username,
+			Subject: "Verify your email address",
+			Body:    fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening this link:\n\n%s\n\nThe link expires in 48 hours.\n", user.FirstName, link),
+		}
+	})
+}
+
+// ForgotPassword emails a reset link. It always answers 202 so it cannot be
+// used to find out which usernames exist.
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var forgotRequest struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&forgotRequest); err != nil || forgotRequest.Username == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	userRepo := repository.NewUserRepository(db)
+	user, err := userRepo.GetUserByEmail(forgotRequest.Username)
+	if err == nil {
+		err = sendUserToken(db, user, repository.TokenPurposePasswordReset, passwordResetTTL, "/password/reset", func(link string) mail.Message {
+			return mail.Message{
+				To:      user.Username,
+				Subject: "Reset your password",
+				Body:    fmt.Sprintf("Hi %s,\n\nSomeone asked to reset your password. If it was you, open this link:\n\n%s\n\nThe link expires in one hour. If you did not ask for this you can ignore this email.\n", user.FirstName, link),
+			}
+		})
+		if err != nil {
+			log.Printf("Failed to send password reset for user %s: %s", user.ID, err)
+		}
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("Failed to fetch user for password reset: %s", err)
+	}
+
+	respondWithJSON(w, http.StatusAccepted, map[string]string{"message": "If the account exists a reset link has been sent"})
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword and signs out every session
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var resetRequest struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&resetRequest); err != nil || resetRequest.Token == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if err := password.ValidatePolicy(resetRequest.Password); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Password must be at least 8 characters")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	tokenRepo := repository.NewUserTokenRepository(db)
+	userID, email, err := tokenRepo.ConsumeToken(repository.TokenPurposePasswordReset, auth.HashToken(resetRequest.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to consume password reset token: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	passwordHash, err := password.Hash(resetRequest.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	userRepo := repository.NewUserRepository(db)
+	if err := userRepo.UpdatePassword(userID, passwordHash); err != nil {
+		log.Printf("Failed to update password for user %s: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	// Whoever knew the old password must not stay signed in
+	revokeUserCredentials(db, userID)
+
+	// Clicking the emailed link also proves the user owns the mailbox
+	if err := userRepo.MarkEmailVerified(userID, email); err != nil {
+		log.Printf("Failed to mark email verified for user %s: %s", userID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyEmail confirms the user's email address using a token from the verification email
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var verifyRequest struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&verifyRequest); err != nil || verifyRequest.Token == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	tokenRepo := repository.NewUserTokenRepository(db)
+	userID, email, err := tokenRepo.ConsumeToken(repository.TokenPurposeEmailVerification, auth.HashToken(verifyRequest.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to consume email verification token: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+
+	userRepo := repository.NewUserRepository(db)
+	if err := userRepo.MarkEmailVerified(userID, email); err != nil {
+		log.Printf("Failed to mark email verified for user %s: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerificationEmail sends the caller a new verification link
+func ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	userRepo := repository.NewUserRepository(db)
+	user, err := userRepo.GetUserByID(principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		respondWithError(w, http.StatusConflict, "Email is already verified")
+		return
+	}
+
+	if err := sendVerificationEmail(db, user); err != nil {
+		log.Printf("Failed to send verification email for user %s: %s", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/repository"
+	"database/sql"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+)
+
+// Logout revokes the caller's access token and, if given, the refresh token family it belongs to
+func Logout(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if principal.AuthMethod != auth.AuthMethodJWT {
+		respondWithError(w, http.StatusBadRequest, "Only token sessions can be logged out; revoke API keys instead")
+		return
+	}
+
+	// The body is optional; a client that holds a refresh token should send it
+	var logoutRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&logoutRequest); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+	}
+
+	if err := auth.RevokeToken(principal); err != nil {
+		log.Printf("Failed to revoke token %s: %s", principal.TokenID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+
+	if logoutRequest.RefreshToken != "" {
+		db, err := db.ConnectDB()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+			return
+		}
+		defer db.Close()
+
+		tokenRepo := repository.NewRefreshTokenRepository(db)
+		token, err := tokenRepo.GetRefreshTokenByHash(auth.HashRefreshToken(logoutRequest.RefreshToken))
+		if err == nil && token.UserID == principal.UserID {
+			if err := tokenRepo.RevokeFamily(token.FamilyID); err != nil {
+				log.Printf("Failed to revoke refresh token family %s: %s", token.FamilyID, err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to log out")
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeUserCredentials signs the user out everywhere after their password
+// changed: access and refresh tokens and API keys stop working. Failures are
+// logged, as the password change itself has already happened.
+func revokeUserCredentials(conn *sql.DB, userID uuid.UUID) {
+	if err := auth.RevokeUserTokens(userID); err != nil {
+		log.Printf("Failed to revoke access tokens for user %s: %s", userID, err)
+	}
+	if err := repository.NewRefreshTokenRepository(conn).RevokeAllForUser(userID); err != nil {
+		log.Printf("Failed to revoke refresh tokens for user %s: %s", userID, err)
+	}
+	if err := repository.NewAPIKeyRepository(conn).RevokeAllForUser(userID); err != nil {
+		log.Printf("Failed to revoke API keys for user %s: %s", userID, err)
+	}
+}
+
+// RevokeUserSessions invalidates every access and refresh token and every API
+// key issued to a user
+func RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	userRepo := scopedUserRepository(db, r)
+	if _, err := userRepo.GetUserByID(userID); err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found with ID: "+userID.String())
+		return
+	}
+
+	if err := auth.RevokeUserTokens(userID); err != nil {
+		log.Printf("Failed to revoke access tokens for user %s: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	tokenRepo := repository.NewRefreshTokenRepository(db)
+	if err := tokenRepo.RevokeAllForUser(userID); err != nil {
+		log.Printf("Failed to revoke refresh tokens for user %s: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	if err := repository.NewAPIKeyRepository(db).RevokeAllForUser(userID); err != nil {
+		log.Printf("Failed to revoke API keys for user %s: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		log.Printf("User %s revoked all sessions of user %s", principal.UserID, userID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/models"
+	"booking-service/repository"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"time"
+)
+
+// accessTokenTTLSeconds is the lifetime of access tokens issued by Login and RefreshToken
+const accessTokenTTLSeconds int64 = 600
+
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// issueTokens generates an access token and a refresh token in the given
+// family, both bound to the tenant
+func issueTokens(conn *sql.DB, user models.User, tenant auth.Tenant, familyID uuid.UUID) (TokenResponse, error) {
+	tokenString, err := auth.GenerateJWT(user.ID, []string{user.Role}, tenant, accessTokenTTLSeconds)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	refreshToken, refreshHash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	tokenRepo := repository.NewRefreshTokenRepository(conn)
+	expiresAt := time.Now().Add(time.Second * time.Duration(auth.RefreshTokenTTLSeconds))
+	if _, err := tokenRepo.InsertRefreshToken(user.ID, familyID, tenant.ID, refreshHash, expiresAt); err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    accessTokenTTLSeconds,
+	}, nil
+}
+
+// issueSessionTokens starts a new session for a user who just signed in,
+// acting in their default organization
+func issueSessionTokens(conn *sql.DB, user models.User) (TokenResponse, error) {
+	tenant, err := defaultTenant(conn, user.ID)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	return issueTokens(conn, user, tenant, uuid.New())
+}
+
+// RefreshToken rotates a refresh token and issues a new access token
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var refreshRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&refreshRequest)
+	if err != nil || refreshRequest.RefreshToken == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	tokenRepo := repository.NewRefreshTokenRepository(db)
+	token, err := tokenRepo.GetRefreshTokenByHash(auth.HashRefreshToken(refreshRequest.RefreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch refresh token: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to refresh token")
+		return
+	}
+
+	if token.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	// A refresh token that was already rotated is being replayed, so the family
+	// must be assumed stolen and every token in it revoked
+	rotated := false
+	if token.UsedAt == nil {
+		rotated, err = tokenRepo.MarkRefreshTokenUsed(token.ID)
+		if err != nil {
+			log.Printf("Failed to mark refresh token %s as used: %s", token.ID, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to refresh token")
+			return
+		}
+	}
+	if !rotated {
+		log.Printf("Refresh token reuse detected for user %s, revoking family %s", token.UserID, token.FamilyID)
+		if err := tokenRepo.RevokeFamily(token.FamilyID); err != nil {
+			log.Printf("Failed to revoke refresh token family %s: %s", token.FamilyID, err)
+		}
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	userRepo := repository.NewUserRepository(db)
+	user, err := userRepo.GetUserByID(token.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	// The organization role is read again so membership changes take effect on
+	// refresh; a session whose membership was removed cannot be refreshed
+	var tenant auth.Tenant
+	if token.TenantID != uuid.Nil {
+		member, err := repository.NewOrganizationRepository(db).GetMembership(token.TenantID, user.ID)
+		if errors.Is(err, repository.ErrNotFound) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to fetch membership of user %s: %s", user.ID, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to refresh token")
+			return
+		}
+		tenant = auth.Tenant{ID: member.OrganizationID, Role: member.Role}
+	}
+
+	tokens, err := issueTokens(db, user, tenant, token.FamilyID)
+	if err != nil {
+		log.Printf("Failed to issue tokens for user %s: %s", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+// GetJWKS publishes the public signing keys so other services can verify our tokens
+func GetJWKS(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"keys": auth.JWKS()})
+}
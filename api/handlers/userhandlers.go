@@ -4,25 +4,31 @@ import (
 	"booking-service/auth"
 	"booking-service/db"
 	"booking-service/models"
+	"booking-service/password"
 	"booking-service/repository"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type UserResponse struct {
-	ID        uuid.UUID  `json:"id"`
-	FirstName string     `json:"first_name"`
-	LastName  string     `json:"last_name"`
-	Role      string     `json:"role"`
-	Username  string     `json:"username"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	DeletedAt *time.Time `json:"deleted_at"`
+	ID              uuid.UUID  `json:"id"`
+	FirstName       string     `json:"first_name"`
+	LastName        string     `json:"last_name"`
+	Role            string     `json:"role"`
+	Username        string     `json:"username"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	DeletedAt       *time.Time `json:"deleted_at"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
 }
 
 func CreateUser(w http.ResponseWriter, r *http.Request) {
@@ -40,25 +46,16 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 	defer db.Close()
 
-	userRepo := repository.NewUserRepository(db)
-	users, err := userRepo.GetAllUsers()
-	if err != nil {
-		log.Printf("Failed to fetch users: %s", err)
-		errorMessage := "Something went wrong while fetching users"
-		respondWithError(w, http.StatusInternalServerError, errorMessage)
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !canManageRole(principal, user.Role) {
+		respondWithDomainError(w, errAdminRoleForbidden, "create user")
 		return
 	}
 
-	for _, existingUser := range users {
-		if existingUser.Username == user.Username {
-			errorMessage := "User already exists with username: " + existingUser.Username
-			respondWithError(w, http.StatusConflict, errorMessage)
-			return
-		}
-	}
+	userRepo := scopedUserRepository(db, r)
 	insertedUser, err := userRepo.InsertUser(user)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create user")
+		respondWithDomainError(w, err, "create user")
 		return
 	}
 	userResponse := UserResponse{
@@ -69,8 +66,7 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		Username:  insertedUser.Username,
 	}
 
-	log.Printf("%s  --> checking user created", userResponse)
-
+	w.Header().Set("ETag", userETag(insertedUser))
 	respondWithJSON(w, http.StatusCreated, userResponse)
 }
 
@@ -85,12 +81,18 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
 	var user models.User
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	user.Version = version
 
 	db, err := db.ConnectDB()
 	if err != nil {
@@ -99,24 +101,40 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	}
 	defer db.Close()
 
-	userRepo := repository.NewUserRepository(db)
+	userRepo := scopedUserRepository(db, r)
 
 	existingUser, err := userRepo.GetUserByID(userID)
 	if err != nil {
-		log.Printf("checking this failed GetUserByID %s", err)
-		errorMessage := "User not found with ID: " + userID.String()
-		respondWithError(w, http.StatusConflict, errorMessage)
+		respondWithDomainError(w, err, "fetch user")
+		return
+	}
+
+	// Only callers allowed to update any user may change roles; owners keep theirs
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !principal.Can(auth.PermUsersUpdate) {
+		if user.Role != "" && user.Role != existingUser.Role {
+			respondWithDomainError(w, &repository.Error{Kind: repository.ErrForbidden, Message: "not allowed to change role"}, "update user")
+			return
+		}
+		user.Role = existingUser.Role
+	}
+	if !canManageRole(principal, existingUser.Role) || !canManageRole(principal, user.Role) {
+		respondWithDomainError(w, errAdminRoleForbidden, "update user")
 		return
 	}
+	if !strings.EqualFold(user.Username, existingUser.Username) || user.Role != existingUser.Role {
+		if err := authorizeAccountChange(userRepo, principal, existingUser); err != nil {
+			respondWithDomainError(w, err, "update user")
+			return
+		}
+	}
 
 	insertedUser, err := userRepo.UpdateUser(user, userID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create user")
+		respondWithDomainError(w, err, "update user")
 		return
 	}
 
-	log.Printf("%s  --> checking user updated", insertedUser)
-
 	userResponse := UserResponse{
 		ID:        insertedUser.ID,
 		FirstName: insertedUser.FirstName,
@@ -127,6 +145,7 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		UpdatedAt: insertedUser.UpdatedAt,
 		DeletedAt: user.DeletedAt,
 	}
+	w.Header().Set("ETag", userETag(insertedUser))
 	respondWithJSON(w, http.StatusOK, userResponse)
 }
 
@@ -140,6 +159,11 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
 	db, err := db.ConnectDB()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
@@ -147,19 +171,26 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	}
 	defer db.Close()
 
-	userRepo := repository.NewUserRepository(db)
+	userRepo := scopedUserRepository(db, r)
 
-	user1, err := userRepo.GetUserByID(userID)
+	existingUser, err := userRepo.GetUserByID(userID)
 	if err != nil {
-		errorMessage := "User not exists with ID: " + userID.String()
-		respondWithError(w, http.StatusBadRequest, errorMessage)
+		respondWithDomainError(w, err, "delete user")
+		return
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !canManageRole(principal, existingUser.Role) {
+		respondWithDomainError(w, errAdminRoleForbidden, "delete user")
+		return
+	}
+	if err := authorizeAccountChange(userRepo, principal, existingUser); err != nil {
+		respondWithDomainError(w, err, "delete user")
 		return
 	}
-	err = userRepo.SoftDeleteUserById(user1.ID)
 
+	err = userRepo.SoftDeleteUserById(userID, version)
 	if err != nil {
-		errorMessage := "Failed to delete user with ID: " + userID.String()
-		respondWithError(w, http.StatusInternalServerError, errorMessage)
+		respondWithDomainError(w, err, "delete user")
 		return
 	}
 
@@ -168,6 +199,43 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// RestoreUser undoes the soft delete of a user that has not yet been purged
+func RestoreUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	userRepo := scopedUserRepository(db, r)
+	deletedUser, err := userRepo.GetUserByIDIncludingDeleted(userID)
+	if err != nil {
+		respondWithDomainError(w, err, "restore user")
+		return
+	}
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if err := authorizeAccountChange(userRepo, principal, deletedUser); err != nil {
+		respondWithDomainError(w, err, "restore user")
+		return
+	}
+
+	user, err := userRepo.RestoreUser(userID)
+	if err != nil {
+		respondWithDomainError(w, err, "restore user")
+		return
+	}
+
+	w.Header().Set("ETag", userETag(user))
+	respondWithJSON(w, http.StatusOK, toUserResponse(user))
+}
+
 func GetUser(w http.ResponseWriter, r *http.Request) {
 	// Get the "id" path variable from the request URL using Gorilla Mux
 	vars := mux.Vars(r)
@@ -189,14 +257,20 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 	defer db.Close()
 
 	// Create a UserRepository instance
-	userRepo := repository.NewUserRepository(db)
+	userRepo := scopedUserRepository(db, r)
 
 	// Retrieve the user by ID from the repository
 	user, err := userRepo.GetUserByID(userID)
-	log.Printf("%s  --> checking user ", user)
 	if err != nil {
-		errorMessage := "User not found with ID: " + userID.String()
-		respondWithError(w, http.StatusBadRequest, errorMessage)
+		respondWithDomainError(w, err, "fetch user")
+		return
+	}
+
+	// Clients revalidating a cached copy get 304 while it is current
+	etag := userETag(user)
+	w.Header().Set("ETag", etag)
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
@@ -216,43 +290,201 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, userResponse)
 }
 
+// Page sizes for GET /users
+const (
+	defaultUserPageSize = 20
+	maxUserPageSize     = 100
+)
+
+// userListCursor is the opaque cursor handed to clients. It records the sort
+// it was issued for so it cannot be replayed against a different ordering.
+type userListCursor struct {
+	Sort     string    `json:"s"`
+	Value    string    `json:"v"`
+	ID       uuid.UUID `json:"id"`
+	Backward bool      `json:"b,omitempty"`
+}
+
+func encodeUserListCursor(sort string, c *repository.UserCursor, backward bool) string {
+	data, _ := json.Marshal(userListCursor{Sort: sort, Value: c.Value, ID: c.ID, Backward: backward})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUserListCursor(s string) (userListCursor, error) {
+	var c userListCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// GetAllUsers lists users a page at a time. Query parameters:
+//
+//	limit            page size, default 20, at most 100
+//	sort             created_at, updated_at, username, first_name or last_name; prefix with - for descending
+//	cursor           next_cursor or prev_cursor from a previous page
+//	role             exact role
+//	username_prefix  case-insensitive username prefix
+//	created_after    RFC 3339 timestamp, inclusive
+//	created_before   RFC 3339 timestamp, exclusive
+//	deleted          exclude (default), include or only
+//	include_total    true to count all matching users
+//
+// Users are written to the response as they are read from the database.
 func GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	// Retrieve all users from the repository
+	opts, sort, err := parseUserListOptions(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	db, err := db.ConnectDB()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
 		return
 	}
 	defer db.Close()
-	userRepo := repository.NewUserRepository(db)
-	users, err := userRepo.GetAllUsers()
-	if err != nil {
+	userRepo := scopedUserRepository(db, r)
 
+	var total *int
+	if r.URL.Query().Get("include_total") == "true" {
+		count, err := userRepo.CountUsers(opts)
+		if err != nil {
+			log.Printf("Failed to count users: %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to get users")
+			return
+		}
+		total = &count
+	}
+
+	// The status line is sent with the first user so query errors can still be reported
+	started := false
+	writeUser := func(user models.User) error {
+		prefix := ","
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			prefix = `{"data":[`
+			started = true
+		}
+		data, err := json.Marshal(toUserResponse(user))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append([]byte(prefix), data...))
+		return err
+	}
+
+	page, err := userRepo.StreamUsers(opts, writeUser)
+	if err != nil && !started {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		log.Printf("Failed to get users: %s", err)
 		respondWithError(w, http.StatusInternalServerError, "Failed to get users")
 		return
 	}
-
-	if len(users) == 0 {
-		w.WriteHeader(http.StatusNoContent)
+	if err != nil {
+		// Too late to change the status; the truncated body tells the client
+		log.Printf("Failed to stream users: %s", err)
 		return
 	}
-	// Create a slice of UserResponse objects without the password for all users
-	userResponses := make([]UserResponse, len(users))
-	for i, user := range users {
-		userResponses[i] = UserResponse{
-			ID:        user.ID,
-			FirstName: user.FirstName,
-			LastName:  user.LastName,
-			Role:      user.Role,
-			Username:  user.Username,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-			DeletedAt: user.DeletedAt,
+
+	// A page is followed by another in the direction it was fetched if HasMore
+	// is set, and in the other direction whenever it was reached by a cursor
+	var next, prev string
+	if page.First != nil {
+		hasNext, hasPrev := page.HasMore, opts.Cursor != nil
+		if opts.Backward {
+			hasNext, hasPrev = opts.Cursor != nil, page.HasMore
+		}
+		if hasNext {
+			next = encodeUserListCursor(sort, page.Last, false)
+		}
+		if hasPrev {
+			prev = encodeUserListCursor(sort, page.First, true)
+		}
+	}
+	trailer, _ := json.Marshal(struct {
+		NextCursor string `json:"next_cursor,omitempty"`
+		PrevCursor string `json:"prev_cursor,omitempty"`
+		Total      *int   `json:"total,omitempty"`
+	}{next, prev, total})
+
+	if !started {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"data":[`))
+	}
+	// Splice the trailer's fields after the data array
+	w.Write([]byte("]"))
+	if len(trailer) > 2 {
+		w.Write([]byte(","))
+	}
+	w.Write(trailer[1:])
+}
+
+// parseUserListOptions reads the GET /users query parameters, returning the
+// options and the sort parameter cursors are tied to
+func parseUserListOptions(r *http.Request) (repository.UserListOptions, string, error) {
+	query := r.URL.Query()
+	opts := repository.UserListOptions{
+		Role:           query.Get("role"),
+		UsernamePrefix: query.Get("username_prefix"),
+		Deleted:        query.Get("deleted"),
+		Limit:          defaultUserPageSize,
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxUserPageSize {
+			return opts, "", fmt.Errorf("limit must be between 1 and %d", maxUserPageSize)
+		}
+		opts.Limit = n
+	}
+
+	sort := query.Get("sort")
+	if sort == "" {
+		sort = "created_at"
+	}
+	opts.SortField = strings.TrimPrefix(sort, "-")
+	opts.SortDesc = opts.SortField != sort
+	if !repository.IsSortField(opts.SortField) {
+		return opts, "", errors.New("Invalid sort field: " + opts.SortField)
+	}
+
+	switch opts.Deleted {
+	case "", repository.DeletedExclude, repository.DeletedInclude, repository.DeletedOnly:
+	default:
+		return opts, "", errors.New("deleted must be exclude, include or only")
+	}
+
+	for param, dst := range map[string]**time.Time{
+		"created_after":  &opts.CreatedAfter,
+		"created_before": &opts.CreatedBefore,
+	} {
+		if v := query.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return opts, "", errors.New(param + " must be an RFC 3339 timestamp")
+			}
+			*dst = &t
 		}
 	}
 
-	// Respond with the list of users (excluding passwords) as JSON
-	respondWithJSON(w, http.StatusOK, userResponses)
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := decodeUserListCursor(v)
+		if err != nil || cursor.Sort != sort {
+			return opts, "", errors.New("Invalid cursor")
+		}
+		opts.Cursor = &repository.UserCursor{Value: cursor.Value, ID: cursor.ID}
+		opts.Backward = cursor.Backward
+	}
+
+	return opts, sort, nil
 }
 
 type User struct {
@@ -284,39 +516,97 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer db.Close()
+	// Refuse attempts while the username or client IP is locked out
+	attemptRepo := repository.NewLoginAttemptRepository(db)
+	counters := loginCounters(r, loginRequest.Username)
+	until, err := lockedUntil(attemptRepo, counters)
+	if err != nil {
+		log.Printf("Failed to check login lockout: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to log in")
+		return
+	}
+	if until != nil {
+		respondLockedOut(w, *until)
+		return
+	}
+
+	// Unknown usernames and wrong passwords get the same response and take the
+	// same time so the endpoint does not reveal which accounts exist
 	userRepo := repository.NewUserRepository(db)
-	// Replace this with your database query to fetch the user by username and password
 	user, err := userRepo.GetUserByEmail(loginRequest.Username)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Username name not exists")
+		if !errors.Is(err, repository.ErrNotFound) {
+			log.Printf("Failed to fetch user for login: %s", err)
+		}
+		password.VerifyDummy(loginRequest.Password)
+		recordLoginFailure(attemptRepo, counters)
+		respondWithError(w, http.StatusUnauthorized, "Invalid username or password")
 		return
 	}
-	
-	log.Printf("checking condition %s and %s", user, loginRequest)
-	if user.Password != loginRequest.Password {
-		log.Printf("checking condition %s and %s", user.Password, loginRequest.Password)
-		respondWithError(w, http.StatusUnauthorized, "Incorrect password")
+
+	match, needsRehash, err := password.Verify(user.Password, loginRequest.Password)
+	if err != nil {
+		log.Printf("Failed to verify password for user %s: %s", user.ID, err)
+	}
+	if !match {
+		recordLoginFailure(attemptRepo, counters)
+		respondWithError(w, http.StatusUnauthorized, "Invalid username or password")
 		return
 	}
-	log.Printf("role type check->  %s", user.Role)
-	if user.Role == "admin" {
-		// Generate a JWT token with admin role
-		tokenString, err := auth.GenerateJWT(user.ID, []string{"admin"}, 600) // 3600 seconds = 1 hour
-		if err != nil {
-			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
-			return
+
+	// A successful login clears the account counter; the IP counter only decays
+	if _, err := attemptRepo.Reset(auth.LockoutAccount, counters[auth.LockoutAccount]); err != nil {
+		log.Printf("Failed to reset login failures for user %s: %s", user.ID, err)
+	}
+
+	// Upgrade plaintext rows and outdated hashes now that we know the password
+	if needsRehash {
+		if newHash, err := password.Hash(loginRequest.Password); err != nil {
+			log.Printf("Failed to rehash password for user %s: %s", user.ID, err)
+		} else if err := userRepo.UpdatePassword(user.ID, newHash); err != nil {
+			log.Printf("Failed to store rehashed password for user %s: %s", user.ID, err)
 		}
+	}
+	// Users with MFA enabled must exchange a challenge token at /login/mfa first
+	challenge, err := mfaChallenge(repository.NewMFARepository(db), user.ID)
+	if err != nil {
+		log.Printf("Failed to check MFA for user %s: %s", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to log in")
+		return
+	}
+	if challenge != nil {
+		respondWithJSON(w, http.StatusOK, challenge)
+		return
+	}
+
+	// Generate an access token carrying the user's role and start a new refresh token family
+	tokens, err := issueSessionTokens(db, user)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	// Respond with the JWT and refresh tokens
+	respondWithJSON(w, http.StatusOK, tokens)
+}
 
-		// Respond with the JWT token
-		respondWithJSON(w, http.StatusOK, map[string]string{"token": tokenString})
-	} else {
-		// Non-admin users are not allowed to log in
-		http.Error(w, "Only admin users are allowed", http.StatusForbidden)
+// toUserResponse converts a user to its public representation without the password
+func toUserResponse(user models.User) UserResponse {
+	return UserResponse{
+		ID:              user.ID,
+		FirstName:       user.FirstName,
+		LastName:        user.LastName,
+		Role:            user.Role,
+		Username:        user.Username,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+		DeletedAt:       user.DeletedAt,
+		EmailVerifiedAt: user.EmailVerifiedAt,
 	}
 }
 
 func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
+	respondWithProblem(w, Problem{Status: code, Detail: message})
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -0,0 +1,476 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/models"
+	"booking-service/password"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits on a single POST /users/import
+const (
+	maxImportRows  = 10000
+	maxImportBytes = 10 << 20
+	// maxImportPasswords bounds the password hashing one request does; each
+	// argon2id hash takes tens of milliseconds and 64 MiB
+	maxImportPasswords = 1000
+	// maxImportHashWorkers bounds how many passwords are hashed at once
+	maxImportHashWorkers = 4
+)
+
+// Media types for bulk import and export
+const (
+	csvMediaType    = "text/csv"
+	ndjsonMediaType = "application/x-ndjson"
+)
+
+// userExportColumns are the columns written by GET /users/export. Import
+// accepts the same columns plus password, ignoring the read-only ones, so an
+// export can be edited and imported again.
+var userExportColumns = []string{"id", "username", "first_name", "last_name", "role", "created_at", "updated_at", "deleted_at", "email_verified_at"}
+
+var (
+	userImportColumns   = map[string]bool{"username": true, "first_name": true, "last_name": true, "role": true, "password": true}
+	userReadOnlyColumns = map[string]bool{"id": true, "created_at": true, "updated_at": true, "deleted_at": true, "email_verified_at": true}
+)
+
+// ImportRow reports what an import did, or would do in a dry run, with one row
+type ImportRow struct {
+	Line     int    `json:"line"`
+	Username string `json:"username"`
+	// Action is create or update
+	Action string `json:"action"`
+}
+
+type ImportResponse struct {
+	DryRun  bool        `json:"dry_run"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// importRecord is one parsed row of an import file
+type importRecord struct {
+	line   int
+	fields map[string]string
+	// problem is set when the row could not be read at all
+	problem string
+}
+
+// ImportUsers creates and updates users in bulk from CSV (with a header row)
+// or NDJSON, matching existing users by case-insensitive username. Every row
+// is validated first; if any row is invalid nothing is written and the errors
+// are reported per line. With ?dry_run=true the rows are only validated.
+// New users imported without a password must set one through password reset.
+func ImportUsers(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var records []importRecord
+	var err error
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	switch mediaType {
+	case csvMediaType:
+		records, err = readCSVImport(body)
+	case ndjsonMediaType, "application/ndjson":
+		records, err = readNDJSONImport(body)
+	default:
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be "+csvMediaType+" or "+ndjsonMediaType)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(records) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No rows to import")
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	// Validate every row before touching the database
+	users := make([]models.User, len(records))
+	lines := map[string]int{}
+	problems := map[string]string{}
+	for i, record := range records {
+		if record.problem != "" {
+			problems[fmt.Sprintf("line %d", record.line)] = record.problem
+			continue
+		}
+		user, fieldProblems := importUser(record.fields)
+		if first, ok := lines[user.Username]; ok && user.Username != "" {
+			fieldProblems["username"] = fmt.Sprintf("duplicates line %d", first)
+		} else {
+			lines[user.Username] = record.line
+		}
+		for field, problem := range fieldProblems {
+			problems[fmt.Sprintf("line %d: %s", record.line, field)] = problem
+		}
+		users[i] = user
+	}
+	if len(problems) > 0 {
+		respondWithProblem(w, Problem{Status: http.StatusUnprocessableEntity, Detail: "Import has invalid rows; nothing was imported", Errors: problems})
+		return
+	}
+	passwords := 0
+	for _, user := range users {
+		if user.Password != "" {
+			passwords++
+		}
+	}
+	if passwords > maxImportPasswords {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Import is limited to %d rows with a password; split it up or leave passwords out", maxImportPasswords))
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+	userRepo := scopedUserRepository(db, r)
+
+	usernames := make([]string, len(users))
+	for i, user := range users {
+		usernames[i] = user.Username
+	}
+	existing, err := userRepo.ExistingUserRoles(usernames)
+	if err != nil {
+		respondWithDomainError(w, err, "import users")
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	for i, user := range users {
+		if !canManageRole(principal, user.Role) || !canManageRole(principal, existing[user.Username]) {
+			problems[fmt.Sprintf("line %d: role", records[i].line)] = errAdminRoleForbidden.Message
+		}
+	}
+	if len(problems) > 0 {
+		respondWithProblem(w, Problem{Status: http.StatusForbidden, Detail: "Import changes admin users; nothing was imported", Errors: problems})
+		return
+	}
+
+	// An organization may only rewrite the accounts it alone holds
+	shared, err := userRepo.SharedUsernames(usernames)
+	if err != nil {
+		respondWithDomainError(w, err, "import users")
+		return
+	}
+	for i, user := range users {
+		if shared[user.Username] {
+			problems[fmt.Sprintf("line %d: username", records[i].line)] = errSharedUserForbidden.Message
+		}
+	}
+	if len(problems) > 0 {
+		respondWithProblem(w, Problem{Status: http.StatusForbidden, Detail: "Import changes users of other organizations; nothing was imported", Errors: problems})
+		return
+	}
+
+	response := ImportResponse{DryRun: dryRun, Rows: make([]ImportRow, len(users))}
+	for i, user := range users {
+		row := ImportRow{Line: records[i].line, Username: user.Username, Action: "create"}
+		if _, ok := existing[user.Username]; ok {
+			row.Action = "update"
+			response.Updated++
+		} else {
+			response.Created++
+		}
+		response.Rows[i] = row
+	}
+	if dryRun {
+		respondWithJSON(w, http.StatusOK, response)
+		return
+	}
+
+	// New users without a password cannot sign in until they reset it
+	for i := range users {
+		if _, ok := existing[users[i].Username]; !ok && users[i].Password == "" {
+			users[i].Password = password.Unusable
+		}
+	}
+	if err := hashImportPasswords(users); err != nil {
+		respondWithDomainError(w, err, "import users")
+		return
+	}
+
+	result, err := userRepo.ImportUsers(users)
+	if err != nil {
+		respondWithDomainError(w, err, "import users")
+		return
+	}
+	response.Created, response.Updated = result.Created, result.Updated
+
+	// As after a password reset, whoever knew the old password must not stay signed in
+	for _, userID := range result.PasswordChanged {
+		revokeUserCredentials(db, userID)
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// hashImportPasswords replaces the plaintext passwords of users with their
+// hashes, a few at a time. Empty and unusable passwords are left as they are.
+func hashImportPasswords(users []models.User) error {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > maxImportHashWorkers {
+		workers = maxImportHashWorkers
+	}
+
+	indexes := make(chan int)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				hashed, err := password.Hash(users[i].Password)
+				if err != nil {
+					errs <- err
+					return
+				}
+				users[i].Password = hashed
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for i, user := range users {
+		if user.Password == "" || user.Password == password.Unusable {
+			continue
+		}
+		select {
+		case indexes <- i:
+		case err = <-errs:
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// importUser validates one row and returns the user it describes
+func importUser(fields map[string]string) (models.User, map[string]string) {
+	problems := map[string]string{}
+	for column := range fields {
+		if !userImportColumns[column] && !userReadOnlyColumns[column] {
+			problems[column] = "is not a known column"
+		}
+	}
+
+	user := models.User{
+		Username:  strings.ToLower(strings.TrimSpace(fields["username"])),
+		FirstName: strings.TrimSpace(fields["first_name"]),
+		LastName:  strings.TrimSpace(fields["last_name"]),
+		Role:      strings.TrimSpace(fields["role"]),
+		Password:  fields["password"],
+	}
+	if user.Username == "" {
+		problems["username"] = "is required"
+	} else if len(user.Username) > 255 {
+		problems["username"] = "must be at most 255 characters"
+	}
+	if user.Role == "" {
+		problems["role"] = "is required"
+	} else if !models.IsRole(user.Role) {
+		problems["role"] = "is not a known role"
+	}
+	if user.Password != "" {
+		if err := password.ValidatePolicy(user.Password); err != nil {
+			problems["password"] = fmt.Sprintf("must be at least %d characters", password.MinLength)
+		}
+	}
+	return user, problems
+}
+
+// readCSVImport reads CSV rows keyed by the header row
+func readCSVImport(body io.Reader) ([]importRecord, error) {
+	reader := csv.NewReader(body)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSV header: %s", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	var records []importRecord
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CSV: %s", err)
+		}
+		if len(records) == maxImportRows {
+			return nil, fmt.Errorf("Import is limited to %d rows", maxImportRows)
+		}
+		line, _ := reader.FieldPos(0)
+		record := importRecord{line: line, fields: map[string]string{}}
+		for i, column := range header {
+			record.fields[column] = values[i]
+		}
+		records = append(records, record)
+	}
+}
+
+// readNDJSONImport reads one JSON object of string fields per line
+func readNDJSONImport(body io.Reader) ([]importRecord, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var records []importRecord
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if len(records) == maxImportRows {
+			return nil, fmt.Errorf("Import is limited to %d rows", maxImportRows)
+		}
+
+		record := importRecord{line: line, fields: map[string]string{}}
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &object); err != nil || object == nil {
+			record.problem = "is not a JSON object"
+		}
+		for column, value := range object {
+			switch value := value.(type) {
+			case string:
+				record.fields[column] = value
+			case nil:
+			default:
+				record.problem = column + " must be a string"
+			}
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("Invalid NDJSON: " + err.Error())
+	}
+	return records, nil
+}
+
+// ExportUsers streams users matching the GET /users filters as CSV or, with
+// ?format=ndjson, as NDJSON, oldest first
+func ExportUsers(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		respondWithError(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+	opts, _, err := parseUserListOptions(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	// Headers go out with the first row so a failing query can still be reported
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		contentType := csvMediaType
+		if format == "ndjson" {
+			contentType = ndjsonMediaType
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="users.`+format+`"`)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	var writeUser func(models.User) error
+	csvWriter := csv.NewWriter(w)
+	if format == "csv" {
+		writeUser = func(user models.User) error {
+			if !started {
+				start()
+				if err := csvWriter.Write(userExportColumns); err != nil {
+					return err
+				}
+			}
+			return csvWriter.Write([]string{
+				user.ID.String(),
+				user.Username,
+				user.FirstName,
+				user.LastName,
+				user.Role,
+				user.CreatedAt.Format(time.RFC3339Nano),
+				user.UpdatedAt.Format(time.RFC3339Nano),
+				formatOptionalTime(user.DeletedAt),
+				formatOptionalTime(user.EmailVerifiedAt),
+			})
+		}
+	} else {
+		encoder := json.NewEncoder(w)
+		writeUser = func(user models.User) error {
+			start()
+			return encoder.Encode(toUserResponse(user))
+		}
+	}
+
+	err = scopedUserRepository(db, r).ExportUsers(opts, writeUser)
+	if err != nil && !started {
+		respondWithDomainError(w, err, "export users")
+		return
+	}
+	if err != nil {
+		// Too late to change the status; the truncated body tells the client
+		log.Printf("Failed to stream user export: %s", err)
+		return
+	}
+
+	if !started {
+		start()
+		if format == "csv" {
+			csvWriter.Write(userExportColumns)
+		}
+	}
+	csvWriter.Flush()
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"booking-service/models"
+	"booking-service/password"
+	"testing"
+)
+
+func TestHashImportPasswords(t *testing.T) {
+	users := []models.User{
+		{Username: "a@example.com", Password: "first password"},
+		{Username: "b@example.com"},
+		{Username: "c@example.com", Password: password.Unusable},
+		{Username: "d@example.com", Password: "second password"},
+	}
+	if err := hashImportPasswords(users); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, plain := range map[int]string{0: "first password", 3: "second password"} {
+		if ok, _, err := password.Verify(users[i].Password, plain); err != nil || !ok || !password.IsHashed(users[i].Password) {
+			t.Errorf("users[%d].Password = %q is not a hash of %q", i, users[i].Password, plain)
+		}
+	}
+	if users[1].Password != "" {
+		t.Errorf("empty password of an existing user changed to %q", users[1].Password)
+	}
+	if users[2].Password != password.Unusable {
+		t.Errorf("unusable password changed to %q", users[2].Password)
+	}
+	if ok, _, _ := password.Verify(users[2].Password, password.Unusable); ok {
+		t.Error("unusable password verified")
+	}
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"booking-service/auth"
+	"booking-service/db"
+	"booking-service/models"
+	"booking-service/repository"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Media types accepted by PATCH /users/{id}
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// patchableUserFields lists the fields a patch may touch and where they live on a UserPatch
+var patchableUserFields = map[string]func(*models.UserPatch) **string{
+	"first_name": func(p *models.UserPatch) **string { return &p.FirstName },
+	"last_name":  func(p *models.UserPatch) **string { return &p.LastName },
+	"role":       func(p *models.UserPatch) **string { return &p.Role },
+	"username":   func(p *models.UserPatch) **string { return &p.Username },
+}
+
+// jsonPatchOperation is one RFC 6902 operation
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// PatchUser applies an RFC 7396 merge patch or, with Content-Type
+// application/json-patch+json, an RFC 6902 JSON Patch to a user. Only the
+// fields whose values change are written, and only callers allowed to update
+// any user may change a role. Like PUT, the request must carry If-Match.
+func PatchUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid UUID format")
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != mergePatchMediaType && mediaType != jsonPatchMediaType && mediaType != "application/json") {
+		w.Header().Set("Accept-Patch", mergePatchMediaType+", "+jsonPatchMediaType)
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be "+mergePatchMediaType+" or "+jsonPatchMediaType)
+		return
+	}
+
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	userRepo := scopedUserRepository(db, r)
+	existingUser, err := userRepo.GetUserByID(userID)
+	if err != nil {
+		respondWithDomainError(w, err, "fetch user")
+		return
+	}
+
+	// Patches are applied to the current values of the patchable fields
+	doc := map[string]string{
+		"first_name": existingUser.FirstName,
+		"last_name":  existingUser.LastName,
+		"role":       existingUser.Role,
+		"username":   existingUser.Username,
+	}
+	if mediaType == jsonPatchMediaType {
+		err = applyJSONPatch(r, doc)
+	} else {
+		err = applyMergePatch(r, doc)
+	}
+	if err != nil {
+		respondWithDomainError(w, err, "patch user")
+		return
+	}
+
+	// Only changed fields are written
+	doc["username"] = strings.ToLower(doc["username"])
+	current := map[string]string{
+		"first_name": existingUser.FirstName,
+		"last_name":  existingUser.LastName,
+		"role":       existingUser.Role,
+		"username":   existingUser.Username,
+	}
+	var patch models.UserPatch
+	for field, value := range doc {
+		if value != current[field] {
+			value := value
+			*patchableUserFields[field](&patch) = &value
+		}
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if patch.Role != nil && !principal.Can(auth.PermUsersUpdate) {
+		respondWithDomainError(w, &repository.Error{Kind: repository.ErrForbidden, Message: "not allowed to change role"}, "patch user")
+		return
+	}
+	if !canManageRole(principal, existingUser.Role) || !canManageRole(principal, doc["role"]) {
+		respondWithDomainError(w, errAdminRoleForbidden, "patch user")
+		return
+	}
+	if patch.Username != nil || patch.Role != nil {
+		if err := authorizeAccountChange(userRepo, principal, existingUser); err != nil {
+			respondWithDomainError(w, err, "patch user")
+			return
+		}
+	}
+
+	updatedUser, err := userRepo.PatchUser(userID, patch, version)
+	if err != nil {
+		respondWithDomainError(w, err, "patch user")
+		return
+	}
+	w.Header().Set("ETag", userETag(updatedUser))
+
+	respondWithJSON(w, http.StatusOK, toUserResponse(updatedUser))
+}
+
+// applyMergePatch applies the RFC 7396 merge patch in the request body to doc
+func applyMergePatch(r *http.Request, doc map[string]string) error {
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		return &repository.Error{Kind: repository.ErrValidation, Message: "merge patch must be a JSON object"}
+	}
+
+	fields := map[string]string{}
+	for field, raw := range patch {
+		if _, ok := patchableUserFields[field]; !ok {
+			fields[field] = "cannot be patched"
+			continue
+		}
+		value, problem := patchString(raw)
+		if problem != "" {
+			fields[field] = problem
+			continue
+		}
+		doc[field] = value
+	}
+	if len(fields) > 0 {
+		return &repository.Error{Kind: repository.ErrValidation, Message: "invalid patch", Fields: fields}
+	}
+	return nil
+}
+
+// applyJSONPatch applies the RFC 6902 JSON Patch in the request body to doc.
+// Every field is required, so remove and move are never valid.
+func applyJSONPatch(r *http.Request, doc map[string]string) error {
+	var ops []jsonPatchOperation
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		return &repository.Error{Kind: repository.ErrValidation, Message: "JSON patch must be an array of operations"}
+	}
+
+	for i, op := range ops {
+		invalid := func(problem string) error {
+			return &repository.Error{
+				Kind:    repository.ErrValidation,
+				Message: "invalid patch",
+				Fields:  map[string]string{fmt.Sprintf("/%d", i): problem},
+			}
+		}
+
+		field, ok := patchPathField(op.Path)
+		if !ok {
+			return invalid("path " + op.Path + " cannot be patched")
+		}
+
+		switch op.Op {
+		case "add", "replace":
+			value, problem := patchString(op.Value)
+			if problem != "" {
+				return invalid("value " + problem)
+			}
+			doc[field] = value
+		case "copy":
+			from, ok := patchPathField(op.From)
+			if !ok {
+				return invalid("from " + op.From + " cannot be read")
+			}
+			doc[field] = doc[from]
+		case "test":
+			value, problem := patchString(op.Value)
+			if problem != "" {
+				return invalid("value " + problem)
+			}
+			if doc[field] != value {
+				return &repository.Error{Kind: repository.ErrConflict, Message: fmt.Sprintf("test failed for %s", op.Path)}
+			}
+		case "remove", "move":
+			return invalid(op.Path + " cannot be removed")
+		default:
+			return invalid("unknown op " + op.Op)
+		}
+	}
+	return nil
+}
+
+// patchPathField returns the field a JSON Pointer such as /first_name names
+func patchPathField(path string) (string, bool) {
+	field := strings.TrimPrefix(path, "/")
+	if field == path {
+		return "", false
+	}
+	_, ok := patchableUserFields[field]
+	return field, ok
+}
+
+// patchString decodes a patch value, which must be a string since every
+// patchable field is a required string
+func patchString(raw json.RawMessage) (string, string) {
+	var value *string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", "must be a string"
+	}
+	if value == nil {
+		return "", "cannot be removed"
+	}
+	return *value, ""
+}
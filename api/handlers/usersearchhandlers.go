@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"booking-service/db"
+	"booking-service/repository"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxSearchQueryLength bounds ?q= so one request cannot build a huge query
+	maxSearchQueryLength = 200
+	// maxSearchOffset stops deep paging, which ranks every match each time
+	maxSearchOffset = 1000
+)
+
+// UserSearchHit is a user matching a search
+type UserSearchHit struct {
+	User UserResponse `json:"user"`
+	Rank float64      `json:"rank"`
+	// Highlights holds first_name, last_name and username, for those
+	// containing a search word, HTML-escaped with each match in <mark> tags
+	Highlights map[string]string `json:"highlights"`
+}
+
+type UserSearchResponse struct {
+	Data       []UserSearchHit `json:"data"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// userSearchCursor is the opaque cursor handed to clients. It records the
+// query it was issued for so it cannot be replayed against another search.
+type userSearchCursor struct {
+	Query  string `json:"q"`
+	Offset int    `json:"o"`
+}
+
+func encodeUserSearchCursor(q string, offset int) string {
+	data, _ := json.Marshal(userSearchCursor{Query: q, Offset: offset})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUserSearchCursor(s string) (userSearchCursor, error) {
+	var c userSearchCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// SearchUsers finds users by partial or misspelt name or username, best match
+// first. Query parameters:
+//
+//	q       search words, required
+//	limit   page size, default 20, at most 100
+//	cursor  next_cursor from a previous page of the same search
+func SearchUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if utf8.RuneCountInString(q) > maxSearchQueryLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("q must be at most %d characters", maxSearchQueryLength))
+		return
+	}
+	terms := repository.SearchTerms(q)
+	if len(terms) == 0 {
+		respondWithError(w, http.StatusBadRequest, "q must contain a letter or digit")
+		return
+	}
+
+	opts := repository.UserSearchOptions{Query: q, Limit: defaultUserPageSize}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxUserPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxUserPageSize))
+			return
+		}
+		opts.Limit = n
+	}
+	if v := query.Get("cursor"); v != "" {
+		c, err := decodeUserSearchCursor(v)
+		if err != nil || c.Query != q || c.Offset < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		opts.Offset = c.Offset
+	}
+	if opts.Offset+opts.Limit > maxSearchOffset {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Only the first %d results can be paged through; refine the search", maxSearchOffset))
+		return
+	}
+
+	db, err := db.ConnectDB()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to connect to the database")
+		return
+	}
+	defer db.Close()
+
+	// One extra row tells whether another page follows
+	limit := opts.Limit
+	opts.Limit++
+	results, err := scopedUserRepository(db, r).SearchUsers(opts)
+	if err != nil {
+		log.Printf("Failed to search users: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to search users")
+		return
+	}
+
+	response := UserSearchResponse{Data: []UserSearchHit{}}
+	if len(results) > limit {
+		results = results[:limit]
+		response.NextCursor = encodeUserSearchCursor(q, opts.Offset+limit)
+	}
+	highlighter := searchHighlighter(terms)
+	for _, result := range results {
+		hit := UserSearchHit{
+			User:       toUserResponse(result.User),
+			Rank:       result.Rank,
+			Highlights: map[string]string{},
+		}
+		for field, text := range map[string]string{
+			"first_name": result.User.FirstName,
+			"last_name":  result.User.LastName,
+			"username":   result.User.Username,
+		} {
+			if highlighted, ok := highlight(highlighter, text); ok {
+				hit.Highlights[field] = highlighted
+			}
+		}
+		response.Data = append(response.Data, hit)
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// searchHighlighter matches any of the terms case-insensitively, preferring
+// the longest where they overlap
+func searchHighlighter(terms []string) *regexp.Regexp {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	return regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}
+
+// highlight HTML-escapes text and wraps each match in <mark> tags. It reports
+// false when nothing matched, as for results found only by typo similarity.
+func highlight(re *regexp.Regexp, text string) (string, bool) {
+	matches := re.FindAllStringIndex(text, -1)
+	if len(matches) == 0 {
+		return "", false
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(html.EscapeString(text[last:m[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[m[0]:m[1]]))
+		b.WriteString("</mark>")
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String(), true
+}
@@ -1,26 +1,91 @@
 package api
 
 import (
-    "github.com/gorilla/mux"
-    "booking-service/api/handlers"
+	"booking-service/api/handlers"
 	"booking-service/auth"
+	"github.com/gorilla/mux"
 	"net/http"
 )
 
-
 func SetupRoutes(r *mux.Router) {
-    r.HandleFunc("/users", handlers.CreateUser).Methods("POST")
-    // r.HandleFunc("/users/{id}", handlers.UpdateUser).Methods("PUT")
-    // r.HandleFunc("/users/{id}", handlers.DeleteUser).Methods("DELETE")
-    // r.HandleFunc("/users/{id}", handlers.GetUser).Methods("GET")
-    // r.HandleFunc("/users", handlers.GetAllUsers).Methods("GET")
+	r.Handle("/users", protect(handlers.CreateUser, auth.RequirePermission(auth.PermUsersCreate))).Methods("POST")
+	// r.HandleFunc("/users/{id}", handlers.UpdateUser).Methods("PUT")
+	// r.HandleFunc("/users/{id}", handlers.DeleteUser).Methods("DELETE")
+	// r.HandleFunc("/users/{id}", handlers.GetUser).Methods("GET")
+	// r.HandleFunc("/users", handlers.GetAllUsers).Methods("GET")
+	r.HandleFunc("/register", handlers.Register).Methods("POST")
 	r.HandleFunc("/login", handlers.Login).Methods("POST")
+	r.HandleFunc("/login/mfa", handlers.LoginMFA).Methods("POST")
+	r.HandleFunc("/oidc/login", handlers.OIDCLogin).Methods("GET")
+	r.HandleFunc("/oidc/callback", handlers.OIDCCallback).Methods("GET")
+	r.HandleFunc("/oauth/token", handlers.OAuthToken).Methods("POST")
+	r.HandleFunc("/oauth/introspect", handlers.IntrospectToken).Methods("POST")
+	r.HandleFunc("/oauth/revoke", handlers.RevokeOAuthToken).Methods("POST")
+	r.HandleFunc("/token/refresh", handlers.RefreshToken).Methods("POST")
+	r.HandleFunc("/password/forgot", handlers.ForgotPassword).Methods("POST")
+	r.HandleFunc("/password/reset", handlers.ResetPassword).Methods("POST")
+	r.HandleFunc("/email/verify", handlers.VerifyEmail).Methods("POST")
+	r.HandleFunc("/invitations/accept", handlers.AcceptInvitation).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", handlers.GetJWKS).Methods("GET")
 
-	
-r.Handle("/users/{id}", auth.ValidateTokenMiddleware(http.HandlerFunc(handlers.UpdateUser))).Methods("PUT")
-r.Handle("/users/{id}", auth.ValidateTokenMiddleware(http.HandlerFunc(handlers.DeleteUser))).Methods("DELETE")
-r.Handle("/users/{id}", auth.ValidateTokenMiddleware(http.HandlerFunc(handlers.GetUser))).Methods("GET")
-r.Handle("/users", auth.ValidateTokenMiddleware(http.HandlerFunc(handlers.GetAllUsers))).Methods("GET")
+	r.Handle("/users/import", protect(handlers.ImportUsers, auth.RequirePermission(auth.PermUsersImport))).Methods("POST")
+	r.Handle("/users/export", protect(handlers.ExportUsers, auth.RequirePermission(auth.PermUsersExport))).Methods("GET")
+	r.Handle("/users/search", protect(handlers.SearchUsers, auth.RequirePermission(auth.PermUsersList))).Methods("GET")
+	r.Handle("/users/{id}", protect(handlers.UpdateUser, auth.RequireOwnerOrPermission(auth.PermUsersUpdate))).Methods("PUT")
+	r.Handle("/users/{id}", protect(handlers.PatchUser, auth.RequireOwnerOrPermission(auth.PermUsersUpdate))).Methods("PATCH")
+	r.Handle("/users/{id}", protect(handlers.DeleteUser, auth.RequirePermission(auth.PermUsersDelete))).Methods("DELETE")
+	r.Handle("/users/{id}/export", protect(handlers.ExportUser, auth.RequireOwnerOrPermission(auth.PermUsersExport))).Methods("GET")
+	r.Handle("/users/{id}/erase", protect(handlers.EraseUser, auth.RequirePermission(auth.PermUsersErase))).Methods("POST")
+	r.Handle("/users/{id}/restore", protect(handlers.RestoreUser, auth.RequirePermission(auth.PermUsersRestore))).Methods("POST")
+	r.Handle("/users/{id}", protect(handlers.GetUser, auth.RequireOwnerOrPermission(auth.PermUsersRead))).Methods("GET")
+	r.Handle("/logout", auth.ValidateTokenMiddleware(http.HandlerFunc(handlers.Logout))).Methods("POST")
+	r.Handle("/users/{id}/sessions/revoke", protect(handlers.RevokeUserSessions, auth.RequirePermission(auth.PermSessionsRevoke))).Methods("POST")
+	r.Handle("/users/{id}/impersonate", protect(handlers.Impersonate, auth.RequirePermission(auth.PermUsersImpersonate))).Methods("POST")
+	r.Handle("/impersonations", protect(handlers.GetImpersonations, auth.RequirePermission(auth.PermUsersImpersonate))).Methods("GET")
+	r.Handle("/lockouts", protect(handlers.GetLockouts, auth.RequirePermission(auth.PermLockoutsManage))).Methods("GET")
+	r.Handle("/lockouts", protect(handlers.ClearLockout, auth.RequirePermission(auth.PermLockoutsManage))).Methods("DELETE")
+	r.Handle("/oauth/authorize", auth.ValidateTokenMiddleware(http.HandlerFunc(handlers.Authorize))).Methods("GET", "POST")
+	r.Handle("/oauth/clients", protect(handlers.RegisterOAuthClient, auth.RequirePermission(auth.PermOAuthClientsManage))).Methods("POST")
+	r.Handle("/oauth/clients", protect(handlers.GetOAuthClients, auth.RequirePermission(auth.PermOAuthClientsManage))).Methods("GET")
+	r.Handle("/oauth/clients/{id}", protect(handlers.RevokeOAuthClient, auth.RequirePermission(auth.PermOAuthClientsManage))).Methods("DELETE")
+	r.Handle("/me", protect(handlers.GetMe, auth.RequireFirstParty)).Methods("GET")
+	r.Handle("/me", protect(handlers.UpdateMe, auth.RequireFirstParty)).Methods("PUT")
+	r.Handle("/me/email/verify", protect(handlers.ResendVerificationEmail, auth.RequireFirstParty)).Methods("POST")
+	r.Handle("/me/api-keys", protect(handlers.CreateAPIKey, auth.RequireFirstParty)).Methods("POST")
+	r.Handle("/me/api-keys", protect(handlers.GetAPIKeys, auth.RequireFirstParty)).Methods("GET")
+	r.Handle("/me/api-keys/{id}", protect(handlers.RevokeAPIKey, auth.RequireFirstParty)).Methods("DELETE")
+	r.Handle("/me/oauth/consents", protect(handlers.GetOAuthConsents, auth.RequireFirstParty)).Methods("GET")
+	r.Handle("/me/oauth/consents/{client_id}", protect(handlers.RevokeOAuthConsent, auth.RequireFirstParty)).Methods("DELETE")
+	r.Handle("/me/mfa/totp", protect(handlers.EnrollTOTP, auth.RequireFirstParty)).Methods("POST")
+	r.Handle("/me/mfa/totp/verify", protect(handlers.ConfirmTOTP, auth.RequireFirstParty)).Methods("POST")
+	r.Handle("/me/mfa/totp", protect(handlers.DisableTOTP, auth.RequireFirstParty)).Methods("DELETE")
+	r.Handle("/users", protect(handlers.GetAllUsers, auth.RequirePermission(auth.PermUsersList))).Methods("GET")
+	r.Handle("/organizations", protect(handlers.CreateOrganization, auth.RequirePermission(auth.PermOrganizationsManage))).Methods("POST")
+	r.Handle("/organizations", protect(handlers.GetOrganizations, auth.RequirePermission(auth.PermOrganizationsManage))).Methods("GET")
+	r.Handle("/organizations/{id}/members", protect(handlers.GetOrganizationMembers, auth.RequirePermission(auth.PermOrgMembersManage))).Methods("GET")
+	r.Handle("/organizations/{id}/members", protect(handlers.AddOrganizationMember, auth.RequirePermission(auth.PermOrgMembersManage))).Methods("POST")
+	r.Handle("/organizations/{id}/members/{user_id}", protect(handlers.RemoveOrganizationMember, auth.RequirePermission(auth.PermOrgMembersManage))).Methods("DELETE")
+	r.Handle("/organizations/{id}/invitations", protect(handlers.CreateInvitation, auth.RequirePermission(auth.PermOrgMembersManage))).Methods("POST")
+	r.Handle("/organizations/{id}/invitations", protect(handlers.GetInvitations, auth.RequirePermission(auth.PermOrgMembersManage))).Methods("GET")
+	r.Handle("/organizations/{id}/invitations/{invitation_id}", protect(handlers.RevokeInvitation, auth.RequirePermission(auth.PermOrgMembersManage))).Methods("DELETE")
+	r.Handle("/groups", protect(handlers.CreateGroup, auth.RequirePermission(auth.PermGroupsManage))).Methods("POST")
+	r.Handle("/groups", protect(handlers.GetGroups, auth.RequirePermission(auth.PermGroupsManage))).Methods("GET")
+	r.Handle("/groups/{id}", protect(handlers.GetGroup, auth.RequirePermission(auth.PermGroupsManage))).Methods("GET")
+	r.Handle("/groups/{id}", protect(handlers.UpdateGroup, auth.RequirePermission(auth.PermGroupsManage))).Methods("PUT")
+	r.Handle("/groups/{id}", protect(handlers.DeleteGroup, auth.RequirePermission(auth.PermGroupsManage))).Methods("DELETE")
+	r.Handle("/groups/{id}/members", protect(handlers.GetGroupMembers, auth.RequirePermission(auth.PermGroupsManage))).Methods("GET")
+	r.Handle("/groups/{id}/members", protect(handlers.AddGroupMember, auth.RequirePermission(auth.PermGroupsManage))).Methods("POST")
+	r.Handle("/groups/{id}/members/{user_id}", protect(handlers.RemoveGroupMember, auth.RequirePermission(auth.PermGroupsManage))).Methods("DELETE")
+	r.Handle("/groups/{id}/subgroups", protect(handlers.GetSubgroups, auth.RequirePermission(auth.PermGroupsManage))).Methods("GET")
+	r.Handle("/groups/{id}/subgroups", protect(handlers.AddSubgroup, auth.RequirePermission(auth.PermGroupsManage))).Methods("POST")
+	r.Handle("/groups/{id}/subgroups/{child_id}", protect(handlers.RemoveSubgroup, auth.RequirePermission(auth.PermGroupsManage))).Methods("DELETE")
+	r.Handle("/users/{id}/groups", protect(handlers.GetUserGroups, auth.RequireOwnerOrPermission(auth.PermUsersRead))).Methods("GET")
+	r.Handle("/me/identities/oidc", protect(handlers.LinkOIDCIdentity, auth.RequireFirstParty)).Methods("POST")
+	r.Handle("/me/organizations", protect(handlers.GetMyOrganizations, auth.RequireFirstParty)).Methods("GET")
+	r.Handle("/token/organization", protect(handlers.SwitchOrganization, auth.RequireFirstParty)).Methods("POST")
+}
 
-	
+// protect wraps a handler with token validation followed by the given authorization policy
+func protect(h http.HandlerFunc, policy func(http.Handler) http.Handler) http.Handler {
+	return auth.ValidateTokenMiddleware(policy(h))
 }
@@ -3,90 +3,235 @@
 package auth
 
 import (
-    "net/http"
-    "github.com/dgrijalva/jwt-go"
-    "github.com/google/uuid"
-    "time"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"strings"
+	"time"
 )
 
-// Define your JWT secret key
-var jwtSecret = []byte("booking")
+// GenerateJWT generates a new JWT token with user claims and a specified expiration time (in seconds).
+// A set tenant binds the token to that organization.
+func GenerateJWT(userID uuid.UUID, userRoles []string, tenant Tenant, expirationSeconds int64) (string, error) {
+	// Calculate the expiration time
+	expirationTime := time.Now().Add(time.Second * time.Duration(expirationSeconds))
+
+	claims := jwt.MapClaims{
+		"jti":     uuid.New().String(), // Lets the token be revoked before it expires
+		"user_id": userID.String(),     // Convert uuid.UUID to string
+		"roles":   userRoles,
+		"iat":     time.Now().Unix(),
+		"exp":     expirationTime.Unix(),
+	}
+	addTenantClaims(claims, tenant)
+	return signClaims(claims)
+}
 
-// GenerateJWT generates a new JWT token with user claims and a specified expiration time (in seconds)
-func GenerateJWT(userID uuid.UUID, userRoles []string, expirationSeconds int64) (string, error) {
-    // Calculate the expiration time
-    expirationTime := time.Now().Add(time.Second * time.Duration(expirationSeconds))
+// signClaims signs the claims with the active key, naming it in the kid header
+func signClaims(claims jwt.MapClaims) (string, error) {
+	key, err := keys.Active()
+	if err != nil {
+		return "", err
+	}
 
-    // Create a new JWT token
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-        "user_id": userID.String(), // Convert uuid.UUID to string
-        "roles":   userRoles,
-        "exp":     expirationTime.Unix(),
-    })
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
 
-    // Sign the token with the secret key
-    tokenString, err := token.SignedString(jwtSecret)
-    if err != nil {
-        return "", err
-    }
+	return token.SignedString(key.Private)
+}
 
-    return tokenString, nil
+// parseToken verifies the token signature against the key named by its kid header
+func parseToken(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, keys.Lookup)
 }
 
-// ValidateTokenMiddleware is middleware for validating JWT tokens
+// ValidateTokenMiddleware is middleware for validating JWT tokens and API keys
 func ValidateTokenMiddleware(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        // Extract the JWT token from the Authorization header
-        tokenString := extractTokenFromRequest(r)
-
-        if tokenString == "" {
-            http.Error(w, "Unauthorized", http.StatusUnauthorized)
-            return
-        }
-
-        // Parse the JWT token
-        token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-            return jwtSecret, nil
-        })
-
-        if err != nil || !token.Valid {
-            http.Error(w, "Unauthorized", http.StatusUnauthorized)
-            return
-        }
-		claims, ok := token.Claims.(jwt.MapClaims)
-        if !ok {
-            http.Error(w, "Unauthorized", http.StatusUnauthorized)
-            return
-        }
-
-		exp, ok := claims["exp"].(float64)
-        if !ok {
-            http.Error(w, "Unauthorized", http.StatusUnauthorized)
-            return
-        }
-
-        // Convert expiration time to Unix timestamp
-        expirationTime := time.Unix(int64(exp), 0)
-
-        // Check if the token has expired
-        if time.Now().After(expirationTime) {
-            http.Error(w, "Token has expired", http.StatusUnauthorized)
-            return
-        }
-
-        // Token is valid; proceed to the next handler
-        next.ServeHTTP(w, r)
-    })
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Machine clients authenticate with "Authorization: ApiKey <key>"
+		if key := strings.TrimPrefix(r.Header.Get("Authorization"), "ApiKey "); key != r.Header.Get("Authorization") {
+			principal, err := authenticateAPIKey(key)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if principal, err = withGroupPermissions(principal); err != nil {
+				log.Printf("Failed to look up group permissions: %s", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+			return
+		}
+
+		// Extract the JWT token from the Authorization header
+		tokenString := extractTokenFromRequest(r)
+
+		if tokenString == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		principal, err := ParseAccessToken(tokenString)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrTokenExpired):
+			http.Error(w, "Token has expired", http.StatusUnauthorized)
+			return
+		case errors.Is(err, ErrTokenRevoked):
+			http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+			return
+		case errors.Is(err, ErrInvalidToken):
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		default:
+			log.Printf("Failed to check token revocation: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if principal, err = withGroupPermissions(principal); err != nil {
+			log.Printf("Failed to look up group permissions: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// Impersonated requests are restricted and audited
+		if principal.Actor != nil {
+			serveImpersonated(next, w, r, principal)
+			return
+		}
+
+		// Token is valid; proceed to the next handler with the caller on the context
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+var (
+	ErrInvalidToken = errors.New("auth: invalid token")
+	ErrTokenExpired = errors.New("auth: token has expired")
+	ErrTokenRevoked = errors.New("auth: token has been revoked")
+)
+
+// ParseAccessToken verifies an access token's signature, expiry and revocation
+// status and returns the principal it was issued to
+func ParseAccessToken(tokenString string) (Principal, error) {
+	// Parse the JWT token
+	token, err := parseToken(tokenString)
+	if err != nil || !token.Valid {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return Principal{}, ErrTokenExpired
+		}
+		return Principal{}, ErrInvalidToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return Principal{}, ErrInvalidToken
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return Principal{}, ErrInvalidToken
+	}
+
+	// Check if the token has expired
+	if time.Now().After(time.Unix(int64(exp), 0)) {
+		return Principal{}, ErrTokenExpired
+	}
+
+	principal, err := principalFromClaims(claims)
+	if err != nil {
+		return Principal{}, ErrInvalidToken
+	}
+
+	// Reject tokens revoked by logout or by an admin
+	revoked, err := isRevoked(principal)
+	if err != nil {
+		return Principal{}, err
+	}
+	if revoked {
+		return Principal{}, ErrTokenRevoked
+	}
+
+	return principal, nil
+}
+
+// principalFromClaims reads the claims written by GenerateJWT
+func principalFromClaims(claims jwt.MapClaims) (Principal, error) {
+	// Single-purpose tokens such as MFA challenges are not access tokens
+	if _, ok := claims["purpose"]; ok {
+		return Principal{}, errors.New("auth: not an access token")
+	}
+
+	// OAuth client credentials tokens act for a client rather than a user
+	clientID, _ := claims["client_id"].(string)
+	userIDStr, _ := claims["user_id"].(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil && !(clientID != "" && userIDStr == "") {
+		return Principal{}, err
+	}
+
+	roles := stringSlice(claims["roles"])
+
+	actor, allowDestructive, err := actorFromClaims(claims)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	tenant, err := tenantFromClaims(claims)
+	if err != nil {
+		return Principal{}, err
+	}
+	// Clients acting for themselves are confined to their organization
+	if userID == uuid.Nil && !tenant.IsSet() {
+		return Principal{}, errors.New("auth: client token without an organization")
+	}
+
+	jtiStr, _ := claims["jti"].(string)
+	tokenID, err := uuid.Parse(jtiStr)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	iat, _ := claims["iat"].(float64)
+	exp, _ := claims["exp"].(float64)
+
+	// Tokens issued to OAuth clients are limited to their granted scopes
+	var scopes []string
+	if scope, ok := claims["scope"].(string); ok {
+		scopes = strings.Fields(scope)
+		if scopes == nil {
+			scopes = []string{}
+		}
+	}
+
+	return Principal{
+		UserID:           userID,
+		Roles:            roles,
+		TokenID:          tokenID,
+		IssuedAt:         time.Unix(int64(iat), 0),
+		ExpiresAt:        time.Unix(int64(exp), 0),
+		AuthMethod:       AuthMethodJWT,
+		ClientID:         clientID,
+		Scopes:           scopes,
+		Actor:            actor,
+		AllowDestructive: allowDestructive,
+		Tenant:           tenant,
+	}, nil
 }
 
 func extractTokenFromRequest(r *http.Request) string {
-    // Retrieve the token from the Authorization header
-    token := r.Header.Get("Authorization")
-    if token != "" {
-        // Check if the header has the "Bearer " prefix and remove it
-        if len(token) > 7 && token[:7] == "Bearer " {
-            return token[7:]
-        }
-    }
-    return ""
+	// Retrieve the token from the Authorization header
+	token := r.Header.Get("Authorization")
+	if token != "" {
+		// Check if the header has the "Bearer " prefix and remove it
+		if len(token) > 7 && token[:7] == "Bearer " {
+			return token[7:]
+		}
+	}
+	return ""
 }
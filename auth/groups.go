@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"github.com/google/uuid"
+)
+
+// GroupPermissionStore finds the permissions users hold through their groups
+type GroupPermissionStore interface {
+	// GroupPermissions returns the permissions granted to the user's groups,
+	// including groups they belong to through nesting, that apply in the
+	// tenant. tenantID is uuid.Nil for sessions outside any organization.
+	GroupPermissions(userID, tenantID uuid.UUID) ([]string, error)
+}
+
+// groupPermissions is nil until SetGroupPermissionStore is called, which leaves
+// permissions to roles alone
+var groupPermissions GroupPermissionStore
+
+// SetGroupPermissionStore makes group permissions count in authorization decisions
+func SetGroupPermissionStore(store GroupPermissionStore) {
+	groupPermissions = store
+}
+
+// withGroupPermissions looks up the group permissions of the user the
+// principal acts for. They are read on every request, so changes to groups
+// take effect immediately.
+func withGroupPermissions(p Principal) (Principal, error) {
+	if groupPermissions == nil || p.UserID == uuid.Nil {
+		return p, nil
+	}
+	permissions, err := groupPermissions.GroupPermissions(p.UserID, p.Tenant.ID)
+	if err != nil {
+		return Principal{}, err
+	}
+	p.GroupPermissions = permissions
+	return p, nil
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"booking-service/models"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// ImpersonationTTLSeconds is the lifetime of impersonation tokens
+const ImpersonationTTLSeconds int64 = 900
+
+// Actor is the user really behind an impersonated request
+type Actor struct {
+	UserID uuid.UUID
+	Roles  []string
+}
+
+// ImpersonationAuditor persists the audit trail of impersonated requests
+type ImpersonationAuditor interface {
+	RecordImpersonatedRequest(req models.ImpersonatedRequest) error
+}
+
+var impersonationAuditor ImpersonationAuditor
+
+// SetImpersonationAuditor configures where impersonated requests are recorded
+func SetImpersonationAuditor(a ImpersonationAuditor) {
+	impersonationAuditor = a
+}
+
+// GenerateImpersonationToken issues a token that lets actor act as the target
+// user in the target's tenant. The act claim (RFC 8693) records who is really
+// making the requests.
+func GenerateImpersonationToken(actor Principal, targetID uuid.UUID, targetRoles []string, tenant Tenant, allowDestructive bool) (string, uuid.UUID, time.Time, error) {
+	now := time.Now()
+	tokenID := uuid.New()
+	expiresAt := now.Add(time.Second * time.Duration(ImpersonationTTLSeconds))
+
+	claims := jwt.MapClaims{
+		"jti":     tokenID.String(),
+		"user_id": targetID.String(),
+		"roles":   targetRoles,
+		"act": map[string]interface{}{
+			"sub":   actor.UserID.String(),
+			"roles": actor.Roles,
+		},
+		"allow_destructive": allowDestructive,
+		"iat":               now.Unix(),
+		"exp":               expiresAt.Unix(),
+	}
+	addTenantClaims(claims, tenant)
+
+	token, err := signClaims(claims)
+	if err != nil {
+		return "", uuid.Nil, time.Time{}, err
+	}
+	return token, tokenID, expiresAt, nil
+}
+
+// actorFromClaims reads the act claim of an impersonation token
+func actorFromClaims(claims jwt.MapClaims) (*Actor, bool, error) {
+	act, ok := claims["act"].(map[string]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+	sub, _ := act["sub"].(string)
+	actorID, err := uuid.Parse(sub)
+	if err != nil {
+		return nil, false, err
+	}
+	allowDestructive, _ := claims["allow_destructive"].(bool)
+	return &Actor{UserID: actorID, Roles: stringSlice(act["roles"])}, allowDestructive, nil
+}
+
+// impersonationWrites are the only requests other than GET an impersonation
+// token may make without allow_destructive. Everything else is refused by
+// default: writes can change the subject's profile or credentials, which
+// could let the actor keep access after the token expires.
+var impersonationWrites = map[string]bool{
+	"POST /logout": true,
+}
+
+// isDestructive reports whether a request may not be made while impersonating
+// unless the token explicitly allows it
+func isDestructive(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return false
+	}
+	return !impersonationWrites[r.Method+" "+r.URL.Path]
+}
+
+// serveImpersonated blocks destructive requests and records every impersonated request
+func serveImpersonated(next http.Handler, w http.ResponseWriter, r *http.Request, principal Principal) {
+	if isDestructive(r) && !principal.AllowDestructive {
+		log.Printf("Blocked impersonated %s %s by %s as %s", r.Method, r.URL.Path, principal.Actor.UserID, principal.UserID)
+		recordImpersonated(principal, r, http.StatusForbidden)
+		http.Error(w, "Destructive operations are not allowed while impersonating", http.StatusForbidden)
+		return
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(recorder, r.WithContext(WithPrincipal(r.Context(), principal)))
+	recordImpersonated(principal, r, recorder.status)
+}
+
+func recordImpersonated(principal Principal, r *http.Request, status int) {
+	req := models.ImpersonatedRequest{
+		TokenID:   principal.TokenID,
+		ActorID:   principal.Actor.UserID,
+		SubjectID: principal.UserID,
+		Method:    r.Method,
+		Path:      r.URL.RequestURI(),
+		Status:    status,
+		At:        time.Now(),
+	}
+	log.Printf("Impersonated request %s %s by %s as %s -> %d", req.Method, req.Path, req.ActorID, req.SubjectID, req.Status)
+	if impersonationAuditor == nil {
+		return
+	}
+	if err := impersonationAuditor.RecordImpersonatedRequest(req); err != nil {
+		log.Printf("Failed to record impersonated request: %s", err)
+	}
+}
+
+// statusRecorder captures the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func stringSlice(raw interface{}) []string {
+	var values []string
+	if items, ok := raw.([]interface{}); ok {
+		for _, item := range items {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
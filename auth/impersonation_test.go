@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsDestructive(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/users/42", false},
+		{"GET", "/me/api-keys", false},
+		{"POST", "/logout", false},
+		{"PUT", "/me", true},
+		{"PUT", "/users/42", true},
+		{"PATCH", "/users/42", true},
+		{"DELETE", "/users/42", true},
+		{"POST", "/users/42/sessions/revoke", true},
+		{"POST", "/me/api-keys", true},
+		{"POST", "/me/identities/oidc", true},
+		{"POST", "/token/organization", true},
+	}
+	for _, tt := range tests {
+		if got := isDestructive(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("isDestructive(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
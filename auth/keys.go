@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningKey is one key in the key set. Keys without a private half can only
+// verify tokens, which is how retired keys are kept around during rotation.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+// KeySet holds every key that may have signed a live token and the one used to sign new tokens
+type KeySet struct {
+	mu     sync.RWMutex
+	keys   map[string]*SigningKey
+	active string
+}
+
+// JWK is the public representation of a key as served from the JWKS endpoint
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+var (
+	ErrUnknownKey = errors.New("auth: unknown signing key")
+	ErrNoKeys     = errors.New("auth: no signing key configured")
+)
+
+// keys is used by GenerateJWT and ValidateTokenMiddleware. It is empty, and no
+// token can be signed or verified, until LoadKeysFromEnv runs.
+var keys = NewKeySet()
+
+func NewKeySet() *KeySet {
+	return &KeySet{keys: map[string]*SigningKey{}}
+}
+
+func (ks *KeySet) Add(key *SigningKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[key.ID] = key
+}
+
+// SetActive selects the key used to sign new tokens
+func (ks *KeySet) SetActive(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key, ok := ks.keys[kid]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if key.Private == nil {
+		return fmt.Errorf("auth: key %q has no private key", kid)
+	}
+	ks.active = kid
+	return nil
+}
+
+// Active returns the key used to sign new tokens
+func (ks *KeySet) Active() (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[ks.active]
+	if !ok {
+		return nil, ErrNoKeys
+	}
+	return key, nil
+}
+
+// Lookup returns the verification key for a token, refusing tokens whose alg
+// header does not match the key so a public key can never be used as an HMAC secret
+func (ks *KeySet) Lookup(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("auth: unexpected signing method %s", token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// JWKS returns the public keys of every asymmetric key in the set
+func (ks *KeySet) JWKS() []JWK {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	jwks := []JWK{}
+	for _, key := range ks.keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = pub.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+		default:
+			// Symmetric secrets are never published
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].Kid < jwks[j].Kid })
+	return jwks
+}
+
+// JWKS returns the public keys downstream services use to verify our tokens
+func JWKS() []JWK {
+	return keys.JWKS()
+}
+
+// LoadKeysFromEnv loads every *.pem file in JWT_KEYS_DIR, using the file name as
+// the kid, and signs with JWT_ACTIVE_KID (or the last kid in sort order).
+// Without JWT_KEYS_DIR it signs with a freshly generated ES256 key, which is
+// published in the JWKS but lost on restart and not shared between instances.
+func LoadKeysFromEnv() error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		key, err := GenerateEphemeralKey()
+		if err != nil {
+			return err
+		}
+		log.Printf("WARNING: JWT_KEYS_DIR is not set; signing tokens with ephemeral key %s. "+
+			"Tokens become invalid on restart and are not accepted by other instances. "+
+			"Configure PEM keys in production.", key.ID)
+		ks := NewKeySet()
+		ks.Add(key)
+		if err := ks.SetActive(key.ID); err != nil {
+			return err
+		}
+		keys = ks
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return ErrNoKeys
+	}
+	sort.Strings(paths)
+
+	ks := NewKeySet()
+	var lastSigner string
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := LoadPEMKey(kid, path)
+		if err != nil {
+			return fmt.Errorf("auth: loading %s: %w", path, err)
+		}
+		ks.Add(key)
+		if key.Private != nil {
+			lastSigner = kid
+		}
+	}
+
+	active := os.Getenv("JWT_ACTIVE_KID")
+	if active == "" {
+		active = lastSigner
+	}
+	if err := ks.SetActive(active); err != nil {
+		return err
+	}
+
+	keys = ks
+	return nil
+}
+
+// GenerateEphemeralKey creates an in-memory ES256 key with a random kid
+func GenerateEphemeralKey() (*SigningKey, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		ID:      "ephemeral-" + hex.EncodeToString(id),
+		Method:  jwt.SigningMethodES256,
+		Private: private,
+		Public:  &private.PublicKey,
+	}, nil
+}
+
+// LoadPEMKey reads an RSA or ECDSA private or public key from a PEM file
+func LoadPEMKey(kid, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{ID: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case *ecdsa.PrivateKey:
+		key.Private, key.Public = k, &k.PublicKey
+		key.Method, err = ecdsaMethod(k.Curve)
+	case *ecdsa.PublicKey:
+		key.Public = k
+		key.Method, err = ecdsaMethod(k.Curve)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func ecdsaMethod(curve elliptic.Curve) (jwt.SigningMethod, error) {
+	switch curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384():
+		return jwt.SigningMethodES384, nil
+	case elliptic.P521():
+		return jwt.SigningMethodES512, nil
+	}
+	return nil, fmt.Errorf("unsupported curve %s", curve.Params().Name)
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"time"
+)
+
+// Kinds of login attempt counters
+const (
+	LockoutAccount = "account"
+	LockoutIP      = "ip"
+	// LockoutMFA counts wrong second-factor codes per user ID
+	LockoutMFA = "mfa"
+)
+
+// LockoutPolicy decides when repeated login failures lock a username or client IP
+type LockoutPolicy struct {
+	// Threshold is the number of failures allowed before the first lockout
+	Threshold int
+	// BaseDelay is the first lockout; each further failure doubles it
+	BaseDelay time.Duration
+	// MaxDelay caps the lockout duration
+	MaxDelay time.Duration
+	// Window is how long failures are remembered after the last one
+	Window time.Duration
+}
+
+// LockoutPolicies holds the policy for each kind of counter. IPs get a higher
+// threshold because many users can share one address.
+var LockoutPolicies = map[string]LockoutPolicy{
+	LockoutAccount: {Threshold: 5, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 15 * time.Minute},
+	LockoutIP:      {Threshold: 20, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 15 * time.Minute},
+	LockoutMFA:     {Threshold: 5, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 15 * time.Minute},
+}
+
+// LockoutDuration returns how long to lock after the given number of consecutive failures
+func (p LockoutPolicy) LockoutDuration(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// MFAChallengeTTLSeconds is how long a user has to enter their second factor after the password
+const MFAChallengeTTLSeconds int64 = 300
+
+const purposeMFA = "mfa"
+
+var ErrInvalidChallenge = errors.New("auth: invalid MFA challenge")
+
+// GenerateMFAChallenge issues the token returned by Login when a second factor
+// is required. It proves the password was checked and cannot be used as an access token.
+func GenerateMFAChallenge(userID uuid.UUID) (string, error) {
+	now := time.Now()
+	return signClaims(jwt.MapClaims{
+		"jti":     uuid.New().String(),
+		"purpose": purposeMFA,
+		"user_id": userID.String(),
+		"iat":     now.Unix(),
+		"exp":     now.Add(time.Second * time.Duration(MFAChallengeTTLSeconds)).Unix(),
+	})
+}
+
+// ParseMFAChallenge verifies a challenge token and returns the user it was issued to
+func ParseMFAChallenge(tokenString string) (uuid.UUID, error) {
+	token, err := parseToken(tokenString)
+	if err != nil || !token.Valid {
+		return uuid.Nil, ErrInvalidChallenge
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != purposeMFA {
+		return uuid.Nil, ErrInvalidChallenge
+	}
+	userIDStr, _ := claims["user_id"].(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return uuid.Nil, ErrInvalidChallenge
+	}
+	return userID, nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"testing"
+
+	"booking-service/models"
+	"github.com/google/uuid"
+)
+
+// useEphemeralKey signs the test's tokens with a fresh key
+func useEphemeralKey(t *testing.T) {
+	t.Helper()
+	key, err := GenerateEphemeralKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := NewKeySet()
+	ks.Add(key)
+	if err := ks.SetActive(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	previous := keys
+	keys = ks
+	t.Cleanup(func() { keys = previous })
+}
+
+func TestClientCredentialsTokenRoundTrip(t *testing.T) {
+	useEphemeralKey(t)
+	tenant := Tenant{ID: uuid.New(), Role: models.OrgRoleMember}
+	token, err := GenerateOAuthAccessToken("cli_partner", uuid.Nil, nil, tenant, []string{PermUsersRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	principal, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatalf("ParseAccessToken() error = %v", err)
+	}
+	if principal.ClientID != "cli_partner" || principal.UserID != uuid.Nil {
+		t.Errorf("principal = %+v, want client cli_partner acting for itself", principal)
+	}
+	if principal.Tenant != tenant {
+		t.Errorf("Tenant = %+v, want %+v", principal.Tenant, tenant)
+	}
+	if !principal.Can(PermUsersRead) || principal.Can(PermUsersUpdate) {
+		t.Errorf("principal not limited to its granted scope: %+v", principal)
+	}
+}
+
+func TestClientTokenWithoutTenantRejected(t *testing.T) {
+	useEphemeralKey(t)
+	token, err := GenerateOAuthAccessToken("cli_partner", uuid.Nil, nil, Tenant{}, []string{PermUsersRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseAccessToken(token); err != ErrInvalidToken {
+		t.Errorf("ParseAccessToken() error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestUserTokenRoundTrip(t *testing.T) {
+	useEphemeralKey(t)
+	userID := uuid.New()
+	token, err := GenerateOAuthAccessToken("cli_partner", userID, []string{models.RoleCustomer}, Tenant{}, []string{PermUsersRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatalf("ParseAccessToken() error = %v", err)
+	}
+	if principal.UserID != userID || principal.ClientID != "cli_partner" {
+		t.Errorf("principal = %+v, want user %s through cli_partner", principal, userID)
+	}
+}
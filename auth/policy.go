@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"booking-service/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// Permissions checked by the API routes
+const (
+	PermUsersCreate = "users:create"
+	PermUsersRead   = "users:read"
+	PermUsersList   = "users:list"
+	PermUsersUpdate = "users:update"
+	PermUsersDelete = "users:delete"
+	// PermUsersRestore allows undoing the soft delete of a user
+	PermUsersRestore = "users:restore"
+	// PermUsersExport allows exporting another user's personal data
+	PermUsersExport = "users:export"
+	// PermUsersErase allows erasing a user's personal data
+	PermUsersErase = "users:erase"
+	// PermUsersImport allows creating and updating users in bulk
+	PermUsersImport = "users:import"
+	// PermOrganizationsManage allows creating organizations and managing any of them
+	PermOrganizationsManage = "organizations:manage"
+	// PermOrgMembersManage allows managing the members of the caller's organization
+	PermOrgMembersManage = "organization_members:manage"
+	// PermGroupsManage allows managing user groups and what they are granted
+	PermGroupsManage = "groups:manage"
+	// PermSessionsRevoke allows revoking every session of another user
+	PermSessionsRevoke = "sessions:revoke"
+	// PermLockoutsManage allows viewing and clearing login lockouts
+	PermLockoutsManage = "lockouts:manage"
+	// PermOAuthClientsManage allows registering and revoking OAuth clients
+	PermOAuthClientsManage = "oauth_clients:manage"
+	// PermUsersImpersonate allows acting as another user
+	PermUsersImpersonate = "users:impersonate"
+)
+
+// rolePermissions maps each role to the permissions it grants
+var rolePermissions = map[string][]string{
+	models.RoleAdmin: {
+		PermUsersCreate,
+		PermUsersRead,
+		PermUsersList,
+		PermUsersUpdate,
+		PermUsersDelete,
+		PermUsersRestore,
+		PermUsersExport,
+		PermUsersErase,
+		PermUsersImport,
+		PermOrganizationsManage,
+		PermOrgMembersManage,
+		PermGroupsManage,
+		PermSessionsRevoke,
+		PermLockoutsManage,
+		PermOAuthClientsManage,
+		PermUsersImpersonate,
+	},
+	// Customers only act on their own account through the owner rules and /me
+	models.RoleCustomer: {},
+}
+
+// IsPermission reports whether permission is one the API checks. Admins hold
+// every permission.
+func IsPermission(permission string) bool {
+	return contains(rolePermissions[models.RoleAdmin], permission)
+}
+
+// RequireRoles allows the request only if the principal holds one of the roles
+func RequireRoles(roles ...string) func(http.Handler) http.Handler {
+	return authorize(func(p Principal, r *http.Request) bool {
+		return p.HasRole(roles...)
+	})
+}
+
+// RequirePermission allows the request only if the principal has the permission
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return authorize(func(p Principal, r *http.Request) bool {
+		return p.Can(permission)
+	})
+}
+
+// RequireOwnerOrPermission allows the request if the principal is the user named
+// by the {id} route variable, or otherwise has the permission
+func RequireOwnerOrPermission(permission string) func(http.Handler) http.Handler {
+	return authorize(func(p Principal, r *http.Request) bool {
+		// Scoped credentials may only act as the owner within their scopes
+		isOwner := p.UserID != uuid.Nil && mux.Vars(r)["id"] == p.UserID.String() &&
+			(p.Scopes == nil || contains(p.Scopes, permission))
+		return isOwner || p.Can(permission)
+	})
+}
+
+// RequireFirstParty rejects tokens issued to OAuth clients, keeping account
+// management out of reach of partner applications. API keys may read the
+// account but not change it: their scopes do not cover account management,
+// and a leaked key must not be enough to take the account over.
+func RequireFirstParty(next http.Handler) http.Handler {
+	return authorize(func(p Principal, r *http.Request) bool {
+		if p.AuthMethod == AuthMethodAPIKey {
+			return r.Method == http.MethodGet || r.Method == http.MethodHead
+		}
+		return p.ClientID == ""
+	})(next)
+}
+
+func authorize(allowed func(Principal, *http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !allowed(principal, r) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRequireFirstParty(t *testing.T) {
+	userID := uuid.New()
+	session := Principal{UserID: userID, AuthMethod: AuthMethodJWT}
+	apiKey := Principal{UserID: userID, AuthMethod: AuthMethodAPIKey, Scopes: []string{}}
+	client := Principal{UserID: userID, AuthMethod: AuthMethodJWT, ClientID: "cli_partner", Scopes: []string{PermUsersRead}}
+
+	tests := []struct {
+		name      string
+		principal Principal
+		method    string
+		want      int
+	}{
+		{"session reads", session, "GET", http.StatusOK},
+		{"session writes", session, "PUT", http.StatusOK},
+		{"API key reads", apiKey, "GET", http.StatusOK},
+		{"API key writes", apiKey, "PUT", http.StatusForbidden},
+		{"API key deletes", apiKey, "DELETE", http.StatusForbidden},
+		{"OAuth client reads", client, "GET", http.StatusForbidden},
+	}
+	handler := RequireFirstParty(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/me", nil)
+		r = r.WithContext(WithPrincipal(r.Context(), tt.principal))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID    uuid.UUID
+	Roles     []string
+	TokenID   uuid.UUID
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+
+	// AuthMethod says whether the caller presented a JWT or an API key
+	AuthMethod string
+	APIKeyID   uuid.UUID
+	// Scopes limits the permissions of the caller's roles; nil means unrestricted
+	Scopes []string
+	// ClientID is set for tokens issued to OAuth clients. Without a UserID the
+	// client acts for itself and its scopes are its permissions.
+	ClientID string
+
+	// Actor is set when an admin is impersonating UserID
+	Actor *Actor
+	// AllowDestructive lets an impersonation token make requests other than reads
+	AllowDestructive bool
+
+	// Tenant is the organization the session acts in, if any
+	Tenant Tenant
+	// GroupPermissions are granted to the user through their groups
+	GroupPermissions []string
+}
+
+// IsImpersonated reports whether someone other than UserID is making the request
+func (p Principal) IsImpersonated() bool {
+	return p.Actor != nil
+}
+
+// Ways a principal can authenticate
+const (
+	AuthMethodJWT    = "jwt"
+	AuthMethodAPIKey = "api_key"
+)
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFromContext returns the principal placed on the context by ValidateTokenMiddleware
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok
+}
+
+// IsClient reports whether an OAuth client is acting on its own behalf
+func (p Principal) IsClient() bool {
+	return p.ClientID != "" && p.UserID == uuid.Nil
+}
+
+// HasRole reports whether the principal holds any of the given roles
+func (p Principal) HasRole(roles ...string) bool {
+	for _, have := range p.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Can reports whether any of the principal's roles, its role in its
+// organization or its groups grant the permission and, for scoped
+// credentials, whether the permission is in scope
+func (p Principal) Can(permission string) bool {
+	if p.Scopes != nil && !contains(p.Scopes, permission) {
+		return false
+	}
+	if p.IsClient() {
+		return true
+	}
+	for _, role := range p.Roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	if contains(p.GroupPermissions, permission) {
+		return true
+	}
+	return p.Tenant.IsSet() && contains(orgRolePermissions[p.Tenant.Role], permission)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// RefreshTokenTTLSeconds is how long a refresh token stays valid (30 days)
+const RefreshTokenTTLSeconds int64 = 30 * 24 * 60 * 60
+
+// GenerateRefreshToken returns a new opaque refresh token and the hash to persist
+func GenerateRefreshToken() (string, string, error) {
+	return GenerateOpaqueToken()
+}
+
+// RandomString returns 256 random bits, URL-safe encoded, for secrets such as
+// tokens, OIDC state and nonces and PKCE verifiers
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// GenerateOpaqueToken returns a random URL-safe token and the hash to persist
+func GenerateOpaqueToken() (string, string, error) {
+	token, err := RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashToken(token), nil
+}
+
+// HashRefreshToken returns the hash under which a refresh token is stored
+func HashRefreshToken(token string) string {
+	return HashToken(token)
+}
+
+// HashToken hashes a high-entropy secret for storage. Unlike passwords these
+// secrets are random, so a fast hash is enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RevocationBackend persists revoked tokens so every instance of the service sees them
+type RevocationBackend interface {
+	// RevokeToken revokes a single access token until it expires
+	RevokeToken(tokenID, userID uuid.UUID, expiresAt time.Time) error
+	// RevokeUserTokens revokes every access token issued to the user before the cutoff
+	RevokeUserTokens(userID uuid.UUID, cutoff time.Time) error
+	// IsTokenRevoked reports whether the token was revoked individually, by a
+	// user cutoff or, for tokens issued to an OAuth client, with the client
+	IsTokenRevoked(tokenID, userID uuid.UUID, clientID string, issuedAt time.Time) (bool, error)
+}
+
+// revocationCacheTTL bounds how long another instance's revocation can go unnoticed
+const revocationCacheTTL = 30 * time.Second
+
+type cachedRevocation struct {
+	revoked bool
+	until   time.Time
+}
+
+// RevocationStore caches lookups against a RevocationBackend. Revoked tokens
+// are cached until they expire; tokens found valid are rechecked after
+// revocationCacheTTL.
+type RevocationStore struct {
+	backend RevocationBackend
+
+	mu        sync.Mutex
+	tokens    map[uuid.UUID]cachedRevocation
+	cutoffs   map[uuid.UUID]time.Time
+	clients   map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewRevocationStore(backend RevocationBackend) *RevocationStore {
+	return &RevocationStore{
+		backend: backend,
+		tokens:  map[uuid.UUID]cachedRevocation{},
+		cutoffs: map[uuid.UUID]time.Time{},
+		clients: map[string]time.Time{},
+	}
+}
+
+// revocations is consulted by ValidateTokenMiddleware; nil disables revocation checks
+var revocations *RevocationStore
+
+// SetRevocationStore configures the store used by ValidateTokenMiddleware and the Revoke functions
+func SetRevocationStore(store *RevocationStore) {
+	revocations = store
+}
+
+// RevokeToken revokes the access token the principal authenticated with
+func RevokeToken(p Principal) error {
+	if revocations == nil {
+		return nil
+	}
+	return revocations.RevokeToken(p)
+}
+
+// RevokeUserTokens revokes every access token issued to the user so far
+func RevokeUserTokens(userID uuid.UUID) error {
+	if revocations == nil {
+		return nil
+	}
+	return revocations.RevokeUserTokens(userID)
+}
+
+// RevokeClientTokens stops every access token issued to the OAuth client from
+// working on this instance straight away. The backend sees the client's own
+// revocation, so other instances follow within revocationCacheTTL.
+func RevokeClientTokens(clientID string) {
+	if revocations == nil {
+		return
+	}
+	revocations.RevokeClientTokens(clientID)
+}
+
+func isRevoked(p Principal) (bool, error) {
+	if revocations == nil {
+		return false, nil
+	}
+	return revocations.IsRevoked(p)
+}
+
+func (s *RevocationStore) RevokeToken(p Principal) error {
+	if err := s.backend.RevokeToken(p.TokenID, p.UserID, p.ExpiresAt); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.tokens[p.TokenID] = cachedRevocation{revoked: true, until: p.ExpiresAt}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *RevocationStore) RevokeUserTokens(userID uuid.UUID) error {
+	// Tokens carry their issue time in whole seconds, so a token issued right
+	// after the cutoff, such as on logging in again after a password reset,
+	// must not compare as issued before it
+	cutoff := time.Now().Truncate(time.Second)
+	if err := s.backend.RevokeUserTokens(userID, cutoff); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.evictExpired(cutoff)
+	s.cutoffs[userID] = cutoff
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *RevocationStore) RevokeClientTokens(clientID string) {
+	now := time.Now()
+	s.mu.Lock()
+	s.evictExpired(now)
+	s.clients[clientID] = now
+	s.mu.Unlock()
+}
+
+func (s *RevocationStore) IsRevoked(p Principal) (bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	if cutoff, ok := s.cutoffs[p.UserID]; ok && p.IssuedAt.Truncate(time.Second).Before(cutoff) {
+		s.mu.Unlock()
+		return true, nil
+	}
+	if _, ok := s.clients[p.ClientID]; p.ClientID != "" && ok {
+		s.mu.Unlock()
+		return true, nil
+	}
+	if cached, ok := s.tokens[p.TokenID]; ok && now.Before(cached.until) {
+		s.mu.Unlock()
+		return cached.revoked, nil
+	}
+	s.mu.Unlock()
+
+	revoked, err := s.backend.IsTokenRevoked(p.TokenID, p.UserID, p.ClientID, p.IssuedAt)
+	if err != nil {
+		return false, err
+	}
+
+	until := now.Add(revocationCacheTTL)
+	if revoked {
+		until = p.ExpiresAt
+	}
+
+	s.mu.Lock()
+	s.evictExpired(now)
+	s.tokens[p.TokenID] = cachedRevocation{revoked: revoked, until: until}
+	s.mu.Unlock()
+
+	return revoked, nil
+}
+
+// evictExpired periodically drops cache entries that no longer matter; callers
+// hold s.mu. A cutoff is only needed until every token cached as valid before
+// it has been rechecked; after that the backend's cutoff applies. The same
+// holds for revoked clients.
+func (s *RevocationStore) evictExpired(now time.Time) {
+	if now.Sub(s.lastSweep) < revocationCacheTTL {
+		return
+	}
+	s.lastSweep = now
+	for id, cached := range s.tokens {
+		if now.After(cached.until) {
+			delete(s.tokens, id)
+		}
+	}
+	for userID, cutoff := range s.cutoffs {
+		if now.Sub(cutoff) > revocationCacheTTL {
+			delete(s.cutoffs, userID)
+		}
+	}
+	for clientID, revokedAt := range s.clients {
+		if now.Sub(revokedAt) > revocationCacheTTL {
+			delete(s.clients, clientID)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memoryBackend is a RevocationBackend that never revokes anything itself
+type memoryBackend struct{}
+
+func (memoryBackend) RevokeToken(tokenID, userID uuid.UUID, expiresAt time.Time) error {
+	return nil
+}
+
+func (memoryBackend) RevokeUserTokens(userID uuid.UUID, cutoff time.Time) error {
+	return nil
+}
+
+func (memoryBackend) IsTokenRevoked(tokenID, userID uuid.UUID, clientID string, issuedAt time.Time) (bool, error) {
+	return false, nil
+}
+
+func TestRevokeClientTokens(t *testing.T) {
+	store := NewRevocationStore(memoryBackend{})
+	now := time.Now()
+	clientToken := Principal{TokenID: uuid.New(), ClientID: "cli_partner", IssuedAt: now, ExpiresAt: now.Add(time.Hour)}
+	userToken := Principal{TokenID: uuid.New(), UserID: uuid.New(), IssuedAt: now, ExpiresAt: now.Add(time.Hour)}
+
+	// Cache both tokens as valid first
+	for _, p := range []Principal{clientToken, userToken} {
+		if revoked, err := store.IsRevoked(p); err != nil || revoked {
+			t.Fatalf("IsRevoked(%+v) = %v, %v before revocation", p, revoked, err)
+		}
+	}
+
+	store.RevokeClientTokens(clientToken.ClientID)
+	if revoked, _ := store.IsRevoked(clientToken); !revoked {
+		t.Error("token of a revoked client still accepted")
+	}
+	if revoked, _ := store.IsRevoked(userToken); revoked {
+		t.Error("first-party token revoked along with the client")
+	}
+}
+
+func TestRevokeUserTokensSparesTokensIssuedInTheSameSecond(t *testing.T) {
+	store := NewRevocationStore(memoryBackend{})
+	userID := uuid.New()
+	before := time.Now().Truncate(time.Second).Add(-time.Second)
+
+	if err := store.RevokeUserTokens(userID); err != nil {
+		t.Fatal(err)
+	}
+	// iat has whole-second precision, so a token issued straight after the
+	// revocation usually carries the revocation's own second
+	after := time.Now().Truncate(time.Second)
+
+	oldToken := Principal{TokenID: uuid.New(), UserID: userID, IssuedAt: before, ExpiresAt: before.Add(time.Hour)}
+	newToken := Principal{TokenID: uuid.New(), UserID: userID, IssuedAt: after, ExpiresAt: after.Add(time.Hour)}
+	if revoked, _ := store.IsRevoked(oldToken); !revoked {
+		t.Error("token issued before the cutoff still accepted")
+	}
+	if revoked, _ := store.IsRevoked(newToken); revoked {
+		t.Error("token issued right after the cutoff rejected")
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"booking-service/models"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// Tenant is the organization a session acts in and the caller's role there.
+// The zero Tenant means the session is not bound to an organization.
+type Tenant struct {
+	ID   uuid.UUID
+	Role string
+}
+
+// orgRolePermissions maps each organization role to the permissions it grants
+// within that organization. Repositories scope what they return to the
+// tenant, so these never reach users of other organizations.
+var orgRolePermissions = map[string][]string{
+	models.OrgRoleOwner: {
+		PermUsersCreate,
+		PermUsersRead,
+		PermUsersList,
+		PermUsersUpdate,
+		PermUsersDelete,
+		PermUsersRestore,
+		PermUsersExport,
+		PermUsersImport,
+		PermOrgMembersManage,
+		PermGroupsManage,
+	},
+	models.OrgRoleAdmin: {
+		PermUsersCreate,
+		PermUsersRead,
+		PermUsersList,
+		PermUsersUpdate,
+		PermUsersDelete,
+		PermUsersRestore,
+		PermOrgMembersManage,
+		PermGroupsManage,
+	},
+	models.OrgRoleMember: {},
+}
+
+// IsSet reports whether the session is bound to an organization
+func (t Tenant) IsSet() bool {
+	return t.ID != uuid.Nil
+}
+
+// addTenantClaims records the tenant in the tid and org_role claims
+func addTenantClaims(claims jwt.MapClaims, tenant Tenant) {
+	if tenant.IsSet() {
+		claims["tid"] = tenant.ID.String()
+		claims["org_role"] = tenant.Role
+	}
+}
+
+// tenantFromClaims reads the claims written by addTenantClaims
+func tenantFromClaims(claims jwt.MapClaims) (Tenant, error) {
+	tid, ok := claims["tid"].(string)
+	if !ok {
+		return Tenant{}, nil
+	}
+	id, err := uuid.Parse(tid)
+	if err != nil {
+		return Tenant{}, err
+	}
+	role, _ := claims["org_role"].(string)
+	if !models.IsOrgRole(role) {
+		return Tenant{}, errors.New("auth: invalid org_role claim")
+	}
+	return Tenant{ID: id, Role: role}, nil
+}
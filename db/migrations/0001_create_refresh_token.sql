@@ -0,0 +1,16 @@
+-- Refresh tokens are opaque; only their SHA-256 hash is stored.
+-- Tokens issued from the same login share a family_id so the whole chain can be
+-- revoked when a rotated token is presented again.
+CREATE TABLE IF NOT EXISTS public.refresh_token (
+    id          uuid PRIMARY KEY,
+    user_id     uuid        NOT NULL REFERENCES public."user" (id),
+    family_id   uuid        NOT NULL,
+    token_hash  text        NOT NULL UNIQUE,
+    expires_at  timestamptz NOT NULL,
+    created_at  timestamptz NOT NULL DEFAULT NOW(),
+    used_at     timestamptz,
+    revoked_at  timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS refresh_token_family_id_idx ON public.refresh_token (family_id);
+CREATE INDEX IF NOT EXISTS refresh_token_user_id_idx ON public.refresh_token (user_id);
@@ -0,0 +1,13 @@
+-- Access tokens revoked individually (logout). Rows can be purged once expires_at has passed.
+CREATE TABLE IF NOT EXISTS public.revoked_token (
+    jti         uuid PRIMARY KEY,
+    user_id     uuid        NOT NULL REFERENCES public."user" (id),
+    expires_at  timestamptz NOT NULL,
+    revoked_at  timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Every access token issued to the user before revoked_before is rejected.
+CREATE TABLE IF NOT EXISTS public.user_token_cutoff (
+    user_id         uuid PRIMARY KEY REFERENCES public."user" (id),
+    revoked_before  timestamptz NOT NULL
+);
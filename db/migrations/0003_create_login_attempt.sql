@@ -0,0 +1,10 @@
+-- Failed login tracking. kind is 'account' (lower-cased username, whether or not
+-- it exists) or 'ip' (client address).
+CREATE TABLE IF NOT EXISTS public.login_attempt (
+    kind            text        NOT NULL,
+    key             text        NOT NULL,
+    failed_count    integer     NOT NULL DEFAULT 0,
+    last_failed_at  timestamptz NOT NULL DEFAULT NOW(),
+    locked_until    timestamptz,
+    PRIMARY KEY (kind, key)
+);
@@ -0,0 +1,20 @@
+-- TOTP enrollment. enabled_at stays NULL until the user confirms a first code.
+-- last_used_step stops a code from being accepted twice.
+CREATE TABLE IF NOT EXISTS public.user_mfa (
+    user_id         uuid PRIMARY KEY REFERENCES public."user" (id),
+    secret          text        NOT NULL,
+    enabled_at      timestamptz,
+    last_used_step  bigint      NOT NULL DEFAULT 0,
+    created_at      timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Single-use recovery codes, stored as SHA-256 hashes.
+CREATE TABLE IF NOT EXISTS public.mfa_recovery_code (
+    id          uuid PRIMARY KEY,
+    user_id     uuid        NOT NULL REFERENCES public."user" (id),
+    code_hash   text        NOT NULL,
+    used_at     timestamptz,
+    created_at  timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS mfa_recovery_code_user_id_idx ON public.mfa_recovery_code (user_id);
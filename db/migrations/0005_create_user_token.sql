@@ -0,0 +1,15 @@
+-- Single-use tokens emailed to users (password reset, email verification).
+-- Only the SHA-256 hash of the token is stored.
+CREATE TABLE IF NOT EXISTS public.user_token (
+    id          uuid PRIMARY KEY,
+    user_id     uuid        NOT NULL REFERENCES public."user" (id),
+    purpose     text        NOT NULL,
+    token_hash  text        NOT NULL UNIQUE,
+    expires_at  timestamptz NOT NULL,
+    used_at     timestamptz,
+    created_at  timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_token_user_id_purpose_idx ON public.user_token (user_id, purpose);
+
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS email_verified_at timestamptz;
@@ -0,0 +1,16 @@
+-- Personal API keys. The key is "bks_<prefix>_<secret>"; prefix identifies the
+-- row and only the SHA-256 hash of the whole key is stored.
+CREATE TABLE IF NOT EXISTS public.api_key (
+    id            uuid PRIMARY KEY,
+    user_id       uuid        NOT NULL REFERENCES public."user" (id),
+    name          text        NOT NULL,
+    prefix        text        NOT NULL UNIQUE,
+    secret_hash   text        NOT NULL,
+    scopes        text[]      NOT NULL DEFAULT '{}',
+    expires_at    timestamptz,
+    last_used_at  timestamptz,
+    created_at    timestamptz NOT NULL DEFAULT NOW(),
+    revoked_at    timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS api_key_user_id_idx ON public.api_key (user_id);
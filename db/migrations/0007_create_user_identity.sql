@@ -0,0 +1,21 @@
+-- External identities linked to local users, one per (provider, subject).
+CREATE TABLE IF NOT EXISTS public.user_identity (
+    id             uuid PRIMARY KEY,
+    user_id        uuid        NOT NULL REFERENCES public."user" (id),
+    provider       text        NOT NULL,
+    subject        text        NOT NULL,
+    email          text,
+    created_at     timestamptz NOT NULL DEFAULT NOW(),
+    last_login_at  timestamptz,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identity_user_id_idx ON public.user_identity (user_id);
+
+-- In-flight OIDC logins between the redirect to the provider and the callback.
+CREATE TABLE IF NOT EXISTS public.oidc_login_state (
+    state          text PRIMARY KEY,
+    nonce          text        NOT NULL,
+    code_verifier  text        NOT NULL,
+    expires_at     timestamptz NOT NULL
+);
@@ -0,0 +1,36 @@
+-- OAuth2 clients registered by admins. Public clients have no secret and must use PKCE.
+CREATE TABLE IF NOT EXISTS public.oauth_client (
+    id              text PRIMARY KEY,
+    secret_hash     text,
+    name            text        NOT NULL,
+    redirect_uris   text[]      NOT NULL DEFAULT '{}',
+    scopes          text[]      NOT NULL DEFAULT '{}',
+    grant_types     text[]      NOT NULL DEFAULT '{}',
+    created_by      uuid        REFERENCES public."user" (id),
+    created_at      timestamptz NOT NULL DEFAULT NOW(),
+    revoked_at      timestamptz
+);
+
+-- Authorization codes are single use and short lived; only their hash is stored.
+CREATE TABLE IF NOT EXISTS public.oauth_authorization_code (
+    code_hash       text PRIMARY KEY,
+    client_id       text        NOT NULL REFERENCES public.oauth_client (id),
+    user_id         uuid        NOT NULL REFERENCES public."user" (id),
+    redirect_uri    text        NOT NULL,
+    scopes          text[]      NOT NULL DEFAULT '{}',
+    code_challenge  text        NOT NULL,
+    expires_at      timestamptz NOT NULL,
+    used_at         timestamptz
+);
+
+-- Scopes a user has agreed to share with a client.
+CREATE TABLE IF NOT EXISTS public.oauth_consent (
+    user_id     uuid        NOT NULL REFERENCES public."user" (id),
+    client_id   text        NOT NULL REFERENCES public.oauth_client (id),
+    scopes      text[]      NOT NULL DEFAULT '{}',
+    granted_at  timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, client_id)
+);
+
+-- Client credentials tokens have no user, but can still be revoked.
+ALTER TABLE public.revoked_token ALTER COLUMN user_id DROP NOT NULL;
@@ -0,0 +1,24 @@
+-- One row per impersonation token an admin was issued.
+CREATE TABLE IF NOT EXISTS public.impersonation_session (
+    jti                uuid PRIMARY KEY,
+    actor_id           uuid        NOT NULL REFERENCES public."user" (id),
+    subject_id         uuid        NOT NULL REFERENCES public."user" (id),
+    reason             text        NOT NULL,
+    allow_destructive  boolean     NOT NULL DEFAULT false,
+    expires_at         timestamptz NOT NULL,
+    created_at         timestamptz NOT NULL DEFAULT NOW()
+);
+
+-- Every request made with an impersonation token.
+CREATE TABLE IF NOT EXISTS public.impersonation_audit (
+    id          uuid PRIMARY KEY,
+    jti         uuid        NOT NULL REFERENCES public.impersonation_session (jti),
+    actor_id    uuid        NOT NULL,
+    subject_id  uuid        NOT NULL,
+    method      text        NOT NULL,
+    path        text        NOT NULL,
+    status      integer     NOT NULL,
+    created_at  timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS impersonation_audit_jti_idx ON public.impersonation_audit (jti);
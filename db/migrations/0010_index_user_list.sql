@@ -0,0 +1,4 @@
+-- Keyset pagination indexes for GET /users
+CREATE INDEX IF NOT EXISTS user_created_at_id_idx ON public."user" (created_at, id);
+CREATE INDEX IF NOT EXISTS user_updated_at_id_idx ON public."user" (updated_at, id);
+CREATE INDEX IF NOT EXISTS user_lower_username_id_idx ON public."user" (lower(username), id);
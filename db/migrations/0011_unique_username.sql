@@ -0,0 +1,3 @@
+-- Usernames are unique case-insensitively among users that have not been
+-- deleted. Resolve any existing duplicates before applying.
+CREATE UNIQUE INDEX IF NOT EXISTS user_username_lower_key ON public."user" (lower(username)) WHERE deleted_at IS NULL;
@@ -0,0 +1,2 @@
+-- Row version for optimistic concurrency control, served as the user's ETag
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
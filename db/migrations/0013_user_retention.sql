@@ -0,0 +1,8 @@
+-- Purged users are removed from the impersonation audit trail rather than
+-- taking it with them, so its user columns become nullable.
+ALTER TABLE public.impersonation_session ALTER COLUMN actor_id DROP NOT NULL;
+ALTER TABLE public.impersonation_session ALTER COLUMN subject_id DROP NOT NULL;
+ALTER TABLE public.impersonation_audit ALTER COLUMN actor_id DROP NOT NULL;
+ALTER TABLE public.impersonation_audit ALTER COLUMN subject_id DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS user_deleted_at_idx ON public."user" (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,15 @@
+-- Right-to-erasure. Erased users keep their row, so references stay valid,
+-- but their personal data is replaced with pseudonyms.
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS erased_at timestamptz;
+
+-- One row per erasure. user_id is deliberately not a foreign key so the
+-- record outlives the purge of the user row.
+CREATE TABLE IF NOT EXISTS public.user_erasure (
+    id            uuid PRIMARY KEY,
+    user_id       uuid        NOT NULL,
+    requested_by  uuid,
+    reason        text        NOT NULL,
+    erased_at     timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_erasure_user_id_idx ON public.user_erasure (user_id);
@@ -0,0 +1,21 @@
+-- Organizations (tenants) and their members. A user can belong to several
+-- organizations with a different role in each.
+CREATE TABLE IF NOT EXISTS public.organization (
+    id          uuid PRIMARY KEY,
+    name        text        NOT NULL,
+    slug        text        NOT NULL UNIQUE,
+    created_at  timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS public.organization_member (
+    organization_id  uuid        NOT NULL REFERENCES public.organization (id),
+    user_id          uuid        NOT NULL REFERENCES public."user" (id),
+    role             text        NOT NULL,
+    created_at       timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS organization_member_user_id_idx ON public.organization_member (user_id);
+
+-- Refresh tokens remember the organization the session was started in
+ALTER TABLE public.refresh_token ADD COLUMN IF NOT EXISTS organization_id uuid REFERENCES public.organization (id);
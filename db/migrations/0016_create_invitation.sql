@@ -0,0 +1,22 @@
+-- Invitations to join an organization. The emailed token is signed and names
+-- the invitation; accepted_at and revoked_at make it single-use.
+CREATE TABLE IF NOT EXISTS public.invitation (
+    id               uuid PRIMARY KEY,
+    organization_id  uuid        NOT NULL REFERENCES public.organization (id),
+    email            text        NOT NULL,
+    role             text        NOT NULL,
+    invited_by       uuid        REFERENCES public."user" (id),
+    user_id          uuid        REFERENCES public."user" (id),
+    expires_at       timestamptz NOT NULL,
+    accepted_at      timestamptz,
+    revoked_at       timestamptz,
+    created_at       timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS invitation_organization_id_created_at_idx ON public.invitation (organization_id, created_at);
+
+-- At most one open invitation per address and organization; a new invitation
+-- revokes the previous one
+CREATE UNIQUE INDEX IF NOT EXISTS invitation_open_email_key
+    ON public.invitation (organization_id, lower(email))
+    WHERE accepted_at IS NULL AND revoked_at IS NULL;
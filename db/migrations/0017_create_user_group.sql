@@ -0,0 +1,35 @@
+-- User groups. Groups created in an organization belong to it; groups with no
+-- organization are managed by platform admins and apply everywhere. Members of
+-- a nested group are members of its parent groups too.
+CREATE TABLE IF NOT EXISTS public.user_group (
+    id               uuid PRIMARY KEY,
+    organization_id  uuid        REFERENCES public.organization (id),
+    name             text        NOT NULL,
+    description      text        NOT NULL DEFAULT '',
+    permissions      text[]      NOT NULL DEFAULT '{}',
+    booking_quota    integer     CHECK (booking_quota >= 0),
+    created_at       timestamptz NOT NULL DEFAULT NOW(),
+    updated_at       timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS user_group_name_key
+    ON public.user_group (coalesce(organization_id, '00000000-0000-0000-0000-000000000000'), lower(name));
+
+CREATE TABLE IF NOT EXISTS public.user_group_member (
+    group_id    uuid        NOT NULL REFERENCES public.user_group (id),
+    user_id     uuid        NOT NULL REFERENCES public."user" (id),
+    created_at  timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS user_group_member_user_id_idx ON public.user_group_member (user_id);
+
+CREATE TABLE IF NOT EXISTS public.user_group_subgroup (
+    parent_id   uuid        NOT NULL REFERENCES public.user_group (id),
+    child_id    uuid        NOT NULL REFERENCES public.user_group (id),
+    created_at  timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (parent_id, child_id),
+    CHECK (parent_id <> child_id)
+);
+
+CREATE INDEX IF NOT EXISTS user_group_subgroup_child_id_idx ON public.user_group_subgroup (child_id);
@@ -0,0 +1,17 @@
+-- Full-text and fuzzy search for GET /users/search. Names weigh more than the
+-- username. The trigram index expression must match userSearchText in
+-- repository/usersearch.go.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', coalesce(first_name, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(last_name, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(username, '')), 'B')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS user_search_vector_idx ON public."user" USING gin (search_vector);
+
+CREATE INDEX IF NOT EXISTS user_search_trgm_idx ON public."user" USING gin (
+    (lower(coalesce(first_name, '') || ' ' || coalesce(last_name, '') || ' ' || coalesce(username, ''))) gin_trgm_ops
+);
@@ -0,0 +1,4 @@
+-- Bind emailed tokens to the address they were sent to, so a link cannot
+-- verify or reset an account whose username has changed since. Tokens issued
+-- before this migration have no address and can no longer be redeemed.
+ALTER TABLE public.user_token ADD COLUMN IF NOT EXISTS email text;
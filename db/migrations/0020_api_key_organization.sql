@@ -0,0 +1,4 @@
+-- Pin API keys to the organization they were created in. Keys act in that
+-- organization with the owner's current role there, and stop working once the
+-- owner leaves it. Keys created outside any organization leave it NULL.
+ALTER TABLE public.api_key ADD COLUMN IF NOT EXISTS organization_id uuid REFERENCES public.organization (id);
@@ -0,0 +1,5 @@
+-- An OIDC flow started by a signed-in user links the external identity to
+-- that user instead of logging in. Existing local accounts are never linked
+-- by email alone.
+ALTER TABLE public.oidc_login_state
+    ADD COLUMN IF NOT EXISTS link_user_id uuid REFERENCES public."user" (id) ON DELETE CASCADE;
@@ -0,0 +1,4 @@
+-- Pin OAuth clients to an organization. Tokens a client obtains for itself
+-- through the client credentials grant act in that organization only, so
+-- clients using that grant must have one.
+ALTER TABLE public.oauth_client ADD COLUMN IF NOT EXISTS organization_id uuid REFERENCES public.organization (id);
@@ -0,0 +1,4 @@
+-- Record the organization an impersonation token acted in, so tenant-scoped
+-- exports can include the sessions of their own organization only. Sessions
+-- outside any organization, and those recorded before this, leave it NULL.
+ALTER TABLE public.impersonation_session ADD COLUMN IF NOT EXISTS organization_id uuid REFERENCES public.organization (id);
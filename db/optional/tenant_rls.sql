@@ -0,0 +1,30 @@
+-- Optional row-level security backing the tenant filters applied by the
+-- repositories. Apply it to have Postgres enforce tenant isolation for
+-- sessions that set app.tenant_id, for example reporting connections:
+--
+--     SET app.tenant_id = '<organization id>';
+--
+-- Sessions that leave app.tenant_id unset, including the API's own
+-- connections, see every row; the API scopes its queries itself. Table owners
+-- bypass RLS unless FORCE ROW LEVEL SECURITY is also set.
+
+ALTER TABLE public."user" ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS user_tenant_isolation ON public."user";
+CREATE POLICY user_tenant_isolation ON public."user"
+    USING (
+        coalesce(current_setting('app.tenant_id', true), '') = ''
+        OR id IN (
+            SELECT user_id FROM public.organization_member
+            WHERE organization_id = current_setting('app.tenant_id', true)::uuid
+        )
+    );
+
+ALTER TABLE public.organization_member ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS organization_member_tenant_isolation ON public.organization_member;
+CREATE POLICY organization_member_tenant_isolation ON public.organization_member
+    USING (
+        coalesce(current_setting('app.tenant_id', true), '') = ''
+        OR organization_id = current_setting('app.tenant_id', true)::uuid
+    );
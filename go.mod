@@ -7,4 +7,7 @@ require (
 	github.com/google/uuid v1.3.1
 	github.com/gorilla/mux v1.8.0
 	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.17.0
 )
+
+require golang.org/x/sys v0.15.0 // indirect
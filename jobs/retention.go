@@ -0,0 +1,48 @@
+// Package jobs runs periodic maintenance in the background.
+package jobs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultUserRetentionDays is how long soft-deleted users can be restored
+// before they are purged, unless USER_RETENTION_DAYS says otherwise
+const DefaultUserRetentionDays = 30
+
+// retentionInterval is how often the retention job runs
+const retentionInterval = 24 * time.Hour
+
+// UserPurger permanently deletes users soft-deleted before a cutoff
+type UserPurger interface {
+	PurgeDeletedUsers(cutoff time.Time) (int, error)
+}
+
+// UserRetentionFromEnv reads USER_RETENTION_DAYS. Zero disables purging.
+func UserRetentionFromEnv() (time.Duration, error) {
+	days := DefaultUserRetentionDays
+	if v := os.Getenv("USER_RETENTION_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("jobs: invalid USER_RETENTION_DAYS %q", v)
+		}
+		days = n
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// StartUserRetention purges users deleted more than retention ago, now and
+// then once a day, until the process exits
+func StartUserRetention(purger UserPurger, retention time.Duration) {
+	go func() {
+		for {
+			if _, err := purger.PurgeDeletedUsers(time.Now().Add(-retention)); err != nil {
+				log.Printf("Failed to purge deleted users: %s", err)
+			}
+			time.Sleep(retentionInterval)
+		}
+	}()
+}
@@ -0,0 +1,27 @@
+package jobs
+
+import (
+	"log"
+	"time"
+)
+
+// revocationCleanupInterval is how often expired revocations are deleted
+const revocationCleanupInterval = time.Hour
+
+// RevocationCleaner deletes revoked tokens that have expired anyway
+type RevocationCleaner interface {
+	DeleteExpiredTokens() (int64, error)
+}
+
+// StartRevocationCleanup deletes expired revoked tokens, now and then every
+// hour, until the process exits
+func StartRevocationCleanup(cleaner RevocationCleaner) {
+	go func() {
+		for {
+			if _, err := cleaner.DeleteExpiredTokens(); err != nil {
+				log.Printf("Failed to delete expired revoked tokens: %s", err)
+			}
+			time.Sleep(revocationCleanupInterval)
+		}
+	}()
+}
@@ -0,0 +1,32 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileMailer writes each message to an .eml file, for local development
+type FileMailer struct {
+	dir string
+	mu  sync.Mutex
+	seq int
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	m.mu.Lock()
+	m.seq++
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().Format("20060102T150405"), m.seq)
+	m.mu.Unlock()
+
+	return os.WriteFile(filepath.Join(m.dir, name), format("booking-service", msg), 0o600)
+}
@@ -0,0 +1,42 @@
+// Package mail sends transactional email such as password reset links.
+package mail
+
+import (
+	"log"
+	"os"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// FromEnv returns an SMTP mailer when SMTP_HOST is set, a file mailer when
+// MAIL_DIR is set, and otherwise an in-memory mailer that only logs
+func FromEnv() (Mailer, error) {
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return NewSMTPMailer(SMTPConfig{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}), nil
+	}
+	if dir := os.Getenv("MAIL_DIR"); dir != "" {
+		return NewFileMailer(dir)
+	}
+	log.Println("No SMTP_HOST or MAIL_DIR configured, emails will only be kept in memory")
+	return NewMemoryMailer(), nil
+}
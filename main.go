@@ -2,8 +2,15 @@ package main
 
 import (
 	"booking-service/api"
+	"booking-service/api/handlers"
+	"booking-service/auth"
 	"booking-service/db"
+	"booking-service/jobs"
+	"booking-service/mail"
+	"booking-service/oidc"
+	"booking-service/repository"
 	"encoding/json"
+	"errors"
 	_ "fmt"
 	"github.com/gorilla/mux"
 	"log"
@@ -17,12 +24,59 @@ type HealthCheckResponse struct {
 }
 
 func main() {
+	// Load the JWT signing keys
+	if err := auth.LoadKeysFromEnv(); err != nil {
+		log.Fatal("Error loading JWT signing keys:", err)
+	}
+
 	// Connect to the database
-	_, err := db.ConnectDB()
+	conn, err := db.ConnectDB()
 	if err != nil {
 		log.Fatal("Error connecting to the database:", err)
 	}
 
+	// Check every authenticated request against the token revocation list
+	revocationRepo := repository.NewRevocationRepository(conn)
+	auth.SetRevocationStore(auth.NewRevocationStore(revocationRepo))
+	jobs.StartRevocationCleanup(revocationRepo)
+
+	// Accept personal API keys alongside JWTs
+	auth.SetAPIKeyStore(repository.NewAPIKeyRepository(conn))
+
+	// Grant users the permissions of their groups
+	auth.SetGroupPermissionStore(repository.NewGroupRepository(conn))
+
+	// Keep an audit trail of requests made while impersonating
+	auth.SetImpersonationAuditor(repository.NewImpersonationRepository(conn))
+
+	// Permanently remove users once they have been deleted for the retention period
+	retention, err := jobs.UserRetentionFromEnv()
+	if err != nil {
+		log.Fatal("Error configuring user retention:", err)
+	}
+	if retention > 0 {
+		jobs.StartUserRetention(repository.NewUserRepository(conn), retention)
+	}
+
+	// Configure how password reset and verification emails are sent
+	mailer, err := mail.FromEnv()
+	if err != nil {
+		log.Fatal("Error configuring the mailer:", err)
+	}
+	handlers.SetMailer(mailer)
+
+	// Enable login through the company identity provider when one is configured
+	oidcConfig, err := oidc.ConfigFromEnv()
+	if err == nil {
+		provider, err := oidc.Discover(oidcConfig)
+		if err != nil {
+			log.Fatal("Error discovering the OIDC provider:", err)
+		}
+		handlers.SetOIDCProvider(provider)
+	} else if !errors.Is(err, oidc.ErrNotConfigured) {
+		log.Fatal("Error configuring OIDC:", err)
+	}
+
 	// Health check handler function
 	healthCheckHandler := func(w http.ResponseWriter, r *http.Request) {
 		// Check the database connection
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	SecretHash string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	// OrganizationID is the organization the key acts in, if any
+	OrganizationID *uuid.UUID `json:"organization_id"`
+	// OwnerRole is the current role of the owning user, loaded when authenticating
+	OwnerRole string `json:"-"`
+	// OwnerOrgRole is the owner's current role in OrganizationID, loaded when authenticating
+	OwnerOrgRole string `json:"-"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// UserErasure records that a user's personal data was erased
+type UserErasure struct {
+	ID          uuid.UUID  `json:"id"`
+	UserID      uuid.UUID  `json:"user_id"`
+	RequestedBy *uuid.UUID `json:"requested_by"`
+	Reason      string     `json:"reason"`
+	ErasedAt    time.Time  `json:"erased_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Group is a team of users that permissions and booking quotas are granted to
+type Group struct {
+	ID uuid.UUID `json:"id"`
+	// OrganizationID is nil for groups that apply in every organization
+	OrganizationID *uuid.UUID `json:"organization_id"`
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	// Permissions are granted to every member, including members of nested groups
+	Permissions []string `json:"permissions"`
+	// BookingQuota is how many active bookings each member may hold; nil sets no quota
+	BookingQuota *int      `json:"booking_quota"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type GroupMember struct {
+	GroupID   uuid.UUID `json:"group_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
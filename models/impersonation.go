@@ -0,0 +1,30 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ImpersonatedRequest is one request made with an impersonation token
+type ImpersonatedRequest struct {
+	TokenID   uuid.UUID
+	ActorID   uuid.UUID
+	SubjectID uuid.UUID
+	Method    string
+	Path      string
+	Status    int
+	At        time.Time
+}
+
+type ImpersonationSession struct {
+	TokenID          uuid.UUID `json:"jti"`
+	ActorID          uuid.UUID `json:"actor_id"`
+	SubjectID        uuid.UUID `json:"subject_id"`
+	Reason           string    `json:"reason"`
+	AllowDestructive bool      `json:"allow_destructive"`
+	// OrganizationID is the organization the token acted in, if any
+	OrganizationID *uuid.UUID `json:"organization_id"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RequestCount   int        `json:"request_count"`
+}
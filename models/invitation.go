@@ -0,0 +1,29 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Invitation statuses, derived from the invitation's timestamps
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationExpired  = "expired"
+	InvitationRevoked  = "revoked"
+)
+
+// Invitation asks someone by email to join an organization with a pre-assigned role
+type Invitation struct {
+	ID             uuid.UUID  `json:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	Status         string     `json:"status"`
+	InvitedBy      *uuid.UUID `json:"invited_by"`
+	UserID         *uuid.UUID `json:"user_id"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+)
+
+type LoginAttempt struct {
+	Kind         string     `json:"kind"`
+	Key          string     `json:"key"`
+	FailedCount  int        `json:"failed_count"`
+	LastFailedAt time.Time  `json:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type UserMFA struct {
+	UserID       uuid.UUID  `json:"user_id"`
+	Secret       string     `json:"-"`
+	EnabledAt    *time.Time `json:"enabled_at"`
+	LastUsedStep int64      `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type OAuthClient struct {
+	ID           string   `json:"client_id"`
+	SecretHash   string   `json:"-"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	// OrganizationID is the organization the client acts in when it acts for itself
+	OrganizationID *uuid.UUID `json:"organization_id"`
+	CreatedBy      *uuid.UUID `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+}
+
+// Confidential reports whether the client authenticates with a secret
+func (c OAuthClient) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+type OAuthAuthorizationCode struct {
+	CodeHash      string    `json:"-"`
+	ClientID      string    `json:"client_id"`
+	UserID        uuid.UUID `json:"user_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scopes        []string  `json:"scopes"`
+	CodeChallenge string    `json:"-"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+type OAuthConsent struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ClientID  string    `json:"client_id"`
+	Scopes    []string  `json:"scopes"`
+	GrantedAt time.Time `json:"granted_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Organization is a tenant; users only see the users of the organization they act in
+type Organization struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrganizationMember struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+	// OrganizationName is filled in when listing a user's memberships
+	OrganizationName string `json:"organization_name,omitempty"`
+}
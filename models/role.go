@@ -0,0 +1,29 @@
+package models
+
+// Roles assigned to users
+const (
+	RoleAdmin = "admin"
+	// RoleCustomer is given to every self-registered user
+	RoleCustomer = "customer"
+)
+
+// IsRole reports whether role is one of the roles users can be assigned
+func IsRole(role string) bool {
+	return role == RoleAdmin || role == RoleCustomer
+}
+
+// Roles a user can hold within an organization
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// IsOrgRole reports whether role is one of the organization roles
+func IsOrgRole(role string) bool {
+	switch role {
+	case OrgRoleOwner, OrgRoleAdmin, OrgRoleMember:
+		return true
+	}
+	return false
+}
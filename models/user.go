@@ -1,18 +1,34 @@
 package models
 
 import (
-    "github.com/google/uuid"
-    "time"
+	"github.com/google/uuid"
+	"time"
 )
 
 type User struct {
-    ID        uuid.UUID    `json:"id"`
-    FirstName string `json:"first_name"`
-    LastName  string `json:"last_name"`
-    Password  string `json:"password"`
-    Role      string `json:"role"`
-    Username  string `json:"username"`
-    CreatedAt time.Time `json:"created_at`
-    UpdatedAt time.Time `json:"updated_at`
-    DeletedAt *time.Time `json:"deleted_at`
+	ID              uuid.UUID  `json:"id"`
+	FirstName       string     `json:"first_name"`
+	LastName        string     `json:"last_name"`
+	Password        string     `json:"password"`
+	Role            string     `json:"role"`
+	Username        string     `json:"username"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	DeletedAt       *time.Time `json:"deleted_at"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// Version is incremented on every write and served as the ETag
+	Version int `json:"-"`
+}
+
+// UserPatch holds the user fields a partial update changes; nil fields are left alone
+type UserPatch struct {
+	FirstName *string
+	LastName  *string
+	Role      *string
+	Username  *string
+}
+
+// IsEmpty reports whether the patch changes nothing
+func (p UserPatch) IsEmpty() bool {
+	return p.FirstName == nil && p.LastName == nil && p.Role == nil && p.Username == nil
 }
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// UserToken is a single-use token emailed to a user. The token itself is
+// never stored, only its hash, which is not exposed.
+type UserToken struct {
+	ID        uuid.UUID  `json:"id"`
+	Purpose   string     `json:"purpose"`
+	Email     string     `json:"email"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,86 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// allowedClockSkew tolerates small clock differences with the provider
+const allowedClockSkew = time.Minute
+
+// Claims are the ID token claims used to link and provision users
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token
+func (p *Provider) VerifyIDToken(raw, nonce string) (Claims, error) {
+	parser := &jwt.Parser{
+		ValidMethods:         []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"},
+		SkipClaimsValidation: true, // validated below with clock skew
+	}
+	token, err := parser.Parse(raw, p.keys.keyFunc)
+	if err != nil || !token.Valid {
+		return Claims{}, fmt.Errorf("oidc: invalid ID token: %v", err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return Claims{}, errors.New("oidc: invalid ID token claims")
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.Discovery.Issuer {
+		return Claims{}, errors.New("oidc: ID token issuer mismatch")
+	}
+	if !audienceContains(claims["aud"], p.Config.ClientID) {
+		return Claims{}, errors.New("oidc: ID token audience mismatch")
+	}
+	// With several audiences the authorized party must be us
+	if azp, ok := claims["azp"].(string); ok && azp != p.Config.ClientID {
+		return Claims{}, errors.New("oidc: ID token authorized party mismatch")
+	}
+
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(allowedClockSkew)) {
+		return Claims{}, errors.New("oidc: ID token expired")
+	}
+	if iat, ok := claims["iat"].(float64); !ok || time.Unix(int64(iat), 0).After(now.Add(allowedClockSkew)) {
+		return Claims{}, errors.New("oidc: ID token issued in the future")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce == "" || tokenNonce != nonce {
+		return Claims{}, errors.New("oidc: ID token nonce mismatch")
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return Claims{}, errors.New("oidc: ID token has no subject")
+	}
+
+	result := Claims{Subject: subject}
+	result.Email, _ = claims["email"].(string)
+	result.EmailVerified, _ = claims["email_verified"].(bool)
+	result.GivenName, _ = claims["given_name"].(string)
+	result.FamilyName, _ = claims["family_name"].(string)
+	return result, nil
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
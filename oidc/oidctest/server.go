@@ -0,0 +1,199 @@
+// Package oidctest provides a stub OpenID Connect provider for tests. It
+// serves discovery, JWKS and token endpoints and issues ID tokens signed with
+// an in-memory ES256 key.
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// KeyID is the kid of the stub provider's signing key
+const KeyID = "stub-key"
+
+// Identity is the user who signs in at the stub provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+// authorization is an issued code waiting to be redeemed
+type authorization struct {
+	identity      Identity
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// Server is a running stub provider
+type Server struct {
+	*httptest.Server
+	Key *ecdsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// NewServer starts a stub provider; callers must Close it
+func NewServer() *Server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{Key: key, codes: map[string]authorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/token", s.token)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Issuer is the issuer URL to configure relying parties with
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// Authorize plays the part of the browser at the authorization endpoint: it
+// reads the request the relying party redirected to, signs in as identity and
+// returns the code the provider would redirect back with, and the state.
+func (s *Server) Authorize(authURL string, identity Identity) (string, string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	params := u.Query()
+	if params.Get("response_type") != "code" {
+		return "", "", errors.New("oidctest: response_type must be code")
+	}
+	if params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == "" {
+		return "", "", errors.New("oidctest: an S256 code_challenge is required")
+	}
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = authorization{
+		identity:      identity,
+		clientID:      params.Get("client_id"),
+		redirectURI:   params.Get("redirect_uri"),
+		nonce:         params.Get("nonce"),
+		codeChallenge: params.Get("code_challenge"),
+	}
+	s.mu.Unlock()
+	return code, params.Get("state"), nil
+}
+
+// SignIDToken signs arbitrary claims with the provider key, for testing how
+// relying parties validate tokens
+func (s *Server) SignIDToken(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = KeyID
+	signed, err := token.SignedString(s.Key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+// IDTokenClaims returns valid ID token claims for the identity
+func (s *Server) IDTokenClaims(identity Identity, clientID, nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            s.Issuer(),
+		"aud":            clientID,
+		"sub":            identity.Subject,
+		"email":          identity.Email,
+		"email_verified": identity.EmailVerified,
+		"given_name":     identity.GivenName,
+		"family_name":    identity.FamilyName,
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.Issuer(),
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"jwks_uri":               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	size := (s.Key.Curve.Params().BitSize + 7) / 8
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "EC",
+			"kid": KeyID,
+			"use": "sig",
+			"alg": "ES256",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(s.Key.X.FillBytes(make([]byte, size))),
+			"y":   base64.RawURLEncoding.EncodeToString(s.Key.Y.FillBytes(make([]byte, size))),
+		}},
+	})
+}
+
+// token redeems a code once, checking it was issued to the same client and
+// redirect URI and that the PKCE verifier matches the challenge
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	s.mu.Lock()
+	auth, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok,
+		r.PostForm.Get("client_id") != auth.clientID,
+		r.PostForm.Get("redirect_uri") != auth.redirectURI,
+		base64.RawURLEncoding.EncodeToString(sum[:]) != auth.codeChallenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     s.SignIDToken(s.IDTokenClaims(auth.identity, auth.clientID, auth.nonce)),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,180 @@
+// Package oidc implements the relying-party side of OpenID Connect: discovery,
+// the authorization code flow with PKCE, and ID token validation.
+package oidc
+
+import (
+	"booking-service/models"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config describes the identity provider and how we are registered with it
+type Config struct {
+	// Name identifies the provider in linked identities, e.g. "corporate"
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// DefaultRole is given to users provisioned on their first login
+	DefaultRole string
+}
+
+// Discovery is the subset of the provider metadata document we use
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is a discovered identity provider
+type Provider struct {
+	Config    Config
+	Discovery Discovery
+
+	client *http.Client
+	keys   *remoteKeySet
+}
+
+var ErrNotConfigured = errors.New("oidc: provider not configured")
+
+// ConfigFromEnv reads the provider settings, returning ErrNotConfigured when OIDC_ISSUER is unset
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		Name:         os.Getenv("OIDC_PROVIDER_NAME"),
+		Issuer:       os.Getenv("OIDC_ISSUER"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       []string{"openid", "email", "profile"},
+		DefaultRole:  os.Getenv("OIDC_DEFAULT_ROLE"),
+	}
+	if config.Issuer == "" {
+		return Config{}, ErrNotConfigured
+	}
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return Config{}, errors.New("oidc: OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required")
+	}
+	if config.DefaultRole != "" && !models.IsRole(config.DefaultRole) {
+		return Config{}, fmt.Errorf("oidc: OIDC_DEFAULT_ROLE %q is not a known role", config.DefaultRole)
+	}
+	if config.Name == "" {
+		config.Name = "oidc"
+	}
+	return config, nil
+}
+
+// Discover fetches the provider metadata from the issuer's well-known endpoint
+func Discover(config Config) (*Provider, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	wellKnown := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := client.Get(wellKnown)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: discovery returned %s", resp.Status)
+	}
+
+	var discovery Discovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, err
+	}
+	// The issuer in the document must be the one we were configured with
+	if discovery.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc: issuer mismatch, expected %q got %q", config.Issuer, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &Provider{
+		Config:    config,
+		Discovery: discovery,
+		client:    client,
+		keys:      newRemoteKeySet(client, discovery.JWKSURI),
+	}, nil
+}
+
+// AuthCodeURL returns the provider URL the browser is redirected to
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.Config.ClientID)
+	params.Set("redirect_uri", p.Config.RedirectURL)
+	params.Set("scope", strings.Join(p.Config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.Discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.Discovery.AuthorizationEndpoint + sep + params.Encode()
+}
+
+// Exchange redeems an authorization code and returns the raw ID token
+func (p *Provider) Exchange(code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("client_id", p.Config.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequest(http.MethodPost, p.Discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || tokenResponse.Error != "" {
+		return "", fmt.Errorf("oidc: token endpoint returned %s: %s %s", resp.Status, tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.IDToken == "" {
+		return "", errors.New("oidc: token response has no id_token")
+	}
+	return tokenResponse.IDToken, nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge for a verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,213 @@
+package oidc_test
+
+import (
+	"booking-service/oidc"
+	"booking-service/oidc/oidctest"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const testClientID = "booking-service"
+
+var testIdentity = oidctest.Identity{
+	Subject:       "subject-1",
+	Email:         "jane@example.com",
+	EmailVerified: true,
+	GivenName:     "Jane",
+	FamilyName:    "Doe",
+}
+
+func discover(t *testing.T, idp *oidctest.Server) *oidc.Provider {
+	t.Helper()
+	provider, err := oidc.Discover(oidc.Config{
+		Name:        "stub",
+		Issuer:      idp.Issuer(),
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost:8080/oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("Discover: %s", err)
+	}
+	return provider
+}
+
+func TestDiscoverRejectsIssuerMismatch(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+
+	_, err := oidc.Discover(oidc.Config{Issuer: idp.Issuer() + "/other", ClientID: testClientID})
+	if err == nil {
+		t.Fatal("expected discovery to fail for a different issuer")
+	}
+}
+
+func TestConfigFromEnvRejectsUnknownDefaultRole(t *testing.T) {
+	t.Setenv("OIDC_ISSUER", "https://idp.example.com")
+	t.Setenv("OIDC_CLIENT_ID", testClientID)
+	t.Setenv("OIDC_REDIRECT_URL", "http://localhost:8080/oidc/callback")
+
+	t.Setenv("OIDC_DEFAULT_ROLE", "superadmin")
+	if _, err := oidc.ConfigFromEnv(); err == nil {
+		t.Error("expected an unknown default role to be rejected")
+	}
+
+	t.Setenv("OIDC_DEFAULT_ROLE", "customer")
+	if _, err := oidc.ConfigFromEnv(); err != nil {
+		t.Errorf("ConfigFromEnv: %s", err)
+	}
+}
+
+// The verifier and challenge are the example from RFC 7636 appendix B
+func TestCodeChallenge(t *testing.T) {
+	got := oidc.CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Fatalf("CodeChallenge = %q, want %q", got, want)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	provider := discover(t, idp)
+
+	u, err := url.Parse(provider.AuthCodeURL("state-1", "nonce-1", oidc.CodeChallenge("verifier")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := u.Query()
+	for name, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge":        oidc.CodeChallenge("verifier"),
+		"code_challenge_method": "S256",
+	} {
+		if got := params.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestExchangeAndVerify(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	provider := discover(t, idp)
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	code, _, err := idp.Authorize(provider.AuthCodeURL("state", "nonce", oidc.CodeChallenge(verifier)), testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := provider.Exchange(code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %s", err)
+	}
+	claims, err := provider.VerifyIDToken(raw, "nonce")
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %s", err)
+	}
+	want := oidc.Claims{Subject: "subject-1", Email: "jane@example.com", EmailVerified: true, GivenName: "Jane", FamilyName: "Doe"}
+	if claims != want {
+		t.Fatalf("claims = %+v, want %+v", claims, want)
+	}
+
+	// Codes are single use
+	if _, err := provider.Exchange(code, verifier); err == nil {
+		t.Fatal("expected a redeemed code to be rejected")
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	provider := discover(t, idp)
+
+	code, _, err := idp.Authorize(provider.AuthCodeURL("state", "nonce", oidc.CodeChallenge("right")), testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Exchange(code, "wrong"); err == nil {
+		t.Fatal("expected the provider to reject a mismatched PKCE verifier")
+	}
+}
+
+func TestVerifyIDTokenRejections(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	provider := discover(t, idp)
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := func() jwt.MapClaims { return idp.IDTokenClaims(testIdentity, testClientID, "nonce") }
+
+	tests := []struct {
+		name string
+		raw  func() string
+	}{
+		{"wrong nonce", func() string {
+			return idp.SignIDToken(idp.IDTokenClaims(testIdentity, testClientID, "other"))
+		}},
+		{"wrong audience", func() string {
+			return idp.SignIDToken(idp.IDTokenClaims(testIdentity, "someone-else", "nonce"))
+		}},
+		{"wrong issuer", func() string {
+			c := valid()
+			c["iss"] = "https://evil.example.com"
+			return idp.SignIDToken(c)
+		}},
+		{"other authorized party", func() string {
+			c := valid()
+			c["aud"] = []string{testClientID, "someone-else"}
+			c["azp"] = "someone-else"
+			return idp.SignIDToken(c)
+		}},
+		{"expired", func() string {
+			c := valid()
+			c["exp"] = time.Now().Add(-time.Hour).Unix()
+			return idp.SignIDToken(c)
+		}},
+		{"issued in the future", func() string {
+			c := valid()
+			c["iat"] = time.Now().Add(time.Hour).Unix()
+			return idp.SignIDToken(c)
+		}},
+		{"no subject", func() string {
+			c := valid()
+			delete(c, "sub")
+			return idp.SignIDToken(c)
+		}},
+		{"signed by another key", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodES256, valid())
+			token.Header["kid"] = oidctest.KeyID
+			raw, _ := token.SignedString(otherKey)
+			return raw
+		}},
+		{"unsigned", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodNone, valid())
+			token.Header["kid"] = oidctest.KeyID
+			raw, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return raw
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := provider.VerifyIDToken(tt.raw(), "nonce"); err == nil {
+				t.Fatal("expected the ID token to be rejected")
+			}
+		})
+	}
+
+	if _, err := provider.VerifyIDToken(idp.SignIDToken(valid()), "nonce"); err != nil {
+		t.Fatalf("valid token rejected: %s", err)
+	}
+}
@@ -0,0 +1,136 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2idParams are the cost parameters for argon2id hashes.
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follows the OWASP recommendation for argon2id.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// ErrInvalidHash is returned when an encoded hash cannot be parsed.
+var ErrInvalidHash = errors.New("password: invalid encoded hash")
+
+// ErrUnsupportedParams is returned for argon2id hashes whose parameters are
+// outside the bounds we are willing to verify. A stored hash must not be able
+// to exhaust memory or CPU on login, or to make any password match.
+var ErrUnsupportedParams = errors.New("password: argon2id parameters out of range")
+
+// Bounds on the parameters of stored argon2id hashes
+const (
+	maxArgon2idMemory      = 1024 * 1024 // KiB
+	maxArgon2idIterations  = 16
+	maxArgon2idParallelism = 16
+	minArgon2idSaltLength  = 8
+	minArgon2idKeyLength   = 16
+	maxArgon2idKeyLength   = 64
+)
+
+type argon2idHasher struct {
+	params Argon2idParams
+}
+
+// NewArgon2id returns a Hasher producing argon2id hashes in the PHC string
+// format: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+func NewArgon2id(params Argon2idParams) Hasher {
+	return &argon2idHasher{params: params}
+}
+
+func (a *argon2idHasher) Hash(plain string) (string, error) {
+	salt := make([]byte, a.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(plain), salt, a.params.Iterations, a.params.Memory, a.params.Parallelism, a.params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		a.params.Memory, a.params.Iterations, a.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *argon2idHasher) Verify(encoded, plain string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(plain), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (a *argon2idHasher) Supports(encoded string) bool {
+	return hasPrefix(encoded, "$argon2id$")
+}
+
+func (a *argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, _, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != a.params.Memory ||
+		params.Iterations != a.params.Iterations ||
+		params.Parallelism != a.params.Parallelism ||
+		params.KeyLength != a.params.KeyLength ||
+		uint32(len(salt)) != a.params.SaltLength
+}
+
+func decodeArgon2id(encoded string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	if params.Parallelism < 1 || params.Parallelism > maxArgon2idParallelism ||
+		params.Iterations < 1 || params.Iterations > maxArgon2idIterations ||
+		params.Memory < 8*uint32(params.Parallelism) || params.Memory > maxArgon2idMemory ||
+		params.SaltLength < minArgon2idSaltLength ||
+		params.KeyLength < minArgon2idKeyLength || params.KeyLength > maxArgon2idKeyLength {
+		return params, nil, nil, ErrUnsupportedParams
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,34 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArgon2idRejectsOutOfRangeParams(t *testing.T) {
+	hasher := NewArgon2id(Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	encoded, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := hasher.Verify(encoded, "correct horse"); err != nil || !ok {
+		t.Fatalf("Verify() = %v, %v for the hash's own password", ok, err)
+	}
+
+	parts := strings.Split(encoded, "$")
+	tests := []struct {
+		name, params, key string
+	}{
+		{"zero parallelism", "m=64,t=1,p=0", parts[5]},
+		{"zero iterations", "m=64,t=0,p=1", parts[5]},
+		{"huge memory", "m=4294967295,t=1,p=1", parts[5]},
+		{"huge iterations", "m=64,t=4294967295,p=1", parts[5]},
+		{"empty key", "m=64,t=1,p=1", ""},
+	}
+	for _, tt := range tests {
+		tampered := strings.Join([]string{"", parts[1], parts[2], tt.params, parts[4], tt.key}, "$")
+		if ok, err := hasher.Verify(tampered, "anything"); err == nil || ok {
+			t.Errorf("%s: Verify() = %v, %v, want an error", tt.name, ok, err)
+		}
+	}
+}
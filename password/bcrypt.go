@@ -0,0 +1,48 @@
+package password
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultBcryptCost is the bcrypt work factor used for new hashes.
+const DefaultBcryptCost = 12
+
+type bcryptHasher struct {
+	cost int
+}
+
+// NewBcrypt returns a Hasher producing bcrypt hashes with the given cost.
+func NewBcrypt(cost int) Hasher {
+	return &bcryptHasher{cost: cost}
+}
+
+func (b *bcryptHasher) Hash(plain string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plain), b.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b *bcryptHasher) Verify(encoded, plain string) (bool, error) {
+	// bcrypt compares in constant time
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(plain))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *bcryptHasher) Supports(encoded string) bool {
+	return hasPrefix(encoded, "$2a$", "$2b$", "$2y$")
+}
+
+func (b *bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.cost
+}
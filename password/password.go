@@ -0,0 +1,116 @@
+package password
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Hasher hashes and verifies passwords for a single algorithm. Encoded hashes
+// carry their own algorithm and cost parameters so they can be verified after
+// the defaults change.
+type Hasher interface {
+	// Hash returns the encoded hash of plain.
+	Hash(plain string) (string, error)
+	// Verify reports whether plain matches the encoded hash.
+	Verify(encoded, plain string) (bool, error)
+	// Supports reports whether encoded was produced by this algorithm.
+	Supports(encoded string) bool
+	// NeedsRehash reports whether encoded uses different parameters than the hasher.
+	NeedsRehash(encoded string) bool
+}
+
+// MinLength is the shortest password accepted for new passwords
+const MinLength = 8
+
+// Unusable is stored in place of a hash for accounts that have no password,
+// such as imported or single sign-on users who never set one. Nothing
+// verifies against it, and being shorter than MinLength it is never chosen.
+const Unusable = "!"
+
+// ErrTooShort is returned by ValidatePolicy for passwords under MinLength
+var ErrTooShort = errors.New("password: must be at least 8 characters")
+
+// ValidatePolicy checks a new password against the password policy
+func ValidatePolicy(plain string) error {
+	if utf8.RuneCountInString(plain) < MinLength {
+		return ErrTooShort
+	}
+	return nil
+}
+
+// Default is the hasher used for new passwords and rehashing on login.
+var Default Hasher = NewArgon2id(DefaultArgon2idParams)
+
+// hashers lists every algorithm that stored hashes may use.
+var hashers = []Hasher{
+	NewArgon2id(DefaultArgon2idParams),
+	NewBcrypt(DefaultBcryptCost),
+}
+
+// Hash hashes plain with the Default hasher.
+func Hash(plain string) (string, error) {
+	return Default.Hash(plain)
+}
+
+// Verify checks plain against a stored password. The second return value is
+// true when the password matched but should be rehashed with Default, which
+// includes legacy plaintext rows and hashes using other algorithms or costs.
+func Verify(stored, plain string) (bool, bool, error) {
+	if stored == Unusable {
+		VerifyDummy(plain)
+		return false, false, nil
+	}
+	for _, h := range hashers {
+		if !h.Supports(stored) {
+			continue
+		}
+		ok, err := h.Verify(stored, plain)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, !Default.Supports(stored) || Default.NeedsRehash(stored), nil
+	}
+
+	// Rows written before hashing was introduced hold the plaintext password
+	ok := subtle.ConstantTimeCompare([]byte(stored), []byte(plain)) == 1
+	return ok, ok, nil
+}
+
+// IsHashed reports whether stored is an encoded hash rather than plaintext.
+func IsHashed(stored string) bool {
+	if stored == Unusable {
+		return true
+	}
+	for _, h := range hashers {
+		if h.Supports(stored) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefix(encoded string, prefixes ...string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(encoded, p) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	dummyOnce sync.Once
+	dummyHash string
+)
+
+// VerifyDummy does the same work as verifying against a real hash so callers can
+// hide whether an account exists from timing
+func VerifyDummy(plain string) {
+	dummyOnce.Do(func() {
+		dummyHash, _ = Default.Hash("dummy password")
+	})
+	Default.Verify(dummyHash, plain)
+}
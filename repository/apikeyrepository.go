@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"booking-service/models"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type APIKeyRepository struct {
+	db *sql.DB
+	tenantScope
+}
+
+func NewAPIKeyRepository(db *sql.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+// ForTenant returns a repository that only lists keys pinned to the
+// organization. uuid.Nil leaves the repository unscoped.
+func (ar *APIKeyRepository) ForTenant(tenantID uuid.UUID) *APIKeyRepository {
+	return &APIKeyRepository{db: ar.db, tenantScope: tenantScope{tenantID: tenantID}}
+}
+
+func (ar *APIKeyRepository) InsertAPIKey(key models.APIKey) (models.APIKey, error) {
+	key.ID = uuid.New()
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+
+	query := `
+        INSERT INTO public.api_key (id, user_id, name, prefix, secret_hash, scopes, expires_at, organization_id, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+        RETURNING created_at
+    `
+	err := ar.db.QueryRow(query, key.ID, key.UserID, key.Name, key.Prefix, key.SecretHash, pq.Array(key.Scopes), key.ExpiresAt, key.OrganizationID).Scan(&key.CreatedAt)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+
+	return key, nil
+}
+
+// GetAPIKeysByUser returns the user's keys that have not been revoked
+func (ar *APIKeyRepository) GetAPIKeysByUser(userID uuid.UUID) ([]models.APIKey, error) {
+	tenant, args := ar.orgCondition("organization_id", []interface{}{userID})
+	query := `
+        SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at, revoked_at, organization_id
+        FROM public.api_key
+        WHERE user_id = $1 AND revoked_at IS NULL AND ` + tenant + `
+        ORDER BY created_at DESC
+    `
+	rows, err := ar.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		var key models.APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Scopes),
+			&key.ExpiresAt,
+			&key.LastUsedAt,
+			&key.CreatedAt,
+			&key.RevokedAt,
+			&key.OrganizationID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// FindAPIKey returns the usable key with the prefix along with its owner's
+// role and, for keys pinned to an organization, their role there. Revoked and
+// expired keys, keys of deleted users and keys whose owner has left the
+// organization are not returned.
+func (ar *APIKeyRepository) FindAPIKey(prefix string) (models.APIKey, error) {
+	query := `
+        SELECT k.id, k.user_id, k.name, k.prefix, k.secret_hash, k.scopes, k.expires_at, k.last_used_at, k.created_at,
+               k.organization_id, u.role, coalesce(m.role, '')
+        FROM public.api_key k
+        JOIN public."user" u ON u.id = k.user_id AND u.deleted_at IS NULL
+        LEFT JOIN public.organization_member m ON m.organization_id = k.organization_id AND m.user_id = k.user_id
+        WHERE k.prefix = $1 AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > NOW())
+          AND (k.organization_id IS NULL OR m.user_id IS NOT NULL)
+    `
+	var key models.APIKey
+	err := ar.db.QueryRow(query, prefix).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.SecretHash,
+		pq.Array(&key.Scopes),
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.CreatedAt,
+		&key.OrganizationID,
+		&key.OwnerRole,
+		&key.OwnerOrgRole,
+	)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
+
+// TouchAPIKey records use of the key, writing at most once a minute per key
+func (ar *APIKeyRepository) TouchAPIKey(id uuid.UUID) error {
+	query := `
+        UPDATE public.api_key SET last_used_at = NOW()
+        WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+    `
+	_, err := ar.db.Exec(query, id)
+	return err
+}
+
+// RevokeAllForUser revokes every outstanding key of the user
+func (ar *APIKeyRepository) RevokeAllForUser(userID uuid.UUID) error {
+	query := `UPDATE public.api_key SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+	_, err := ar.db.Exec(query, userID)
+	return err
+}
+
+// RevokeAPIKey revokes one of the user's keys, returning false if it does not exist
+func (ar *APIKeyRepository) RevokeAPIKey(userID, id uuid.UUID) (bool, error) {
+	query := `
+        UPDATE public.api_key SET revoked_at = NOW()
+        WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+    `
+	result, err := ar.db.Exec(query, id, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
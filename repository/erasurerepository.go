@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"booking-service/models"
+	"booking-service/password"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"log"
+)
+
+// Pseudonyms written over an erased user's name
+const (
+	erasedFirstName = "Erased"
+	erasedLastName  = "User"
+)
+
+var errUserAlreadyErased = &Error{Kind: ErrConflict, Message: "user already erased"}
+
+type ErasureRepository struct {
+	db *sql.DB
+}
+
+func NewErasureRepository(db *sql.DB) *ErasureRepository {
+	return &ErasureRepository{db: db}
+}
+
+// EraseUser pseudonymizes the user's personal data, deletes their
+// credentials, sessions and linked accounts, soft-deletes them if needed and
+// records the erasure, all in one transaction. The user row itself is kept so
+// references to it stay valid until the retention job purges it.
+func (er *ErasureRepository) EraseUser(erasure models.UserErasure) (models.UserErasure, error) {
+	tx, err := er.db.Begin()
+	if err != nil {
+		return models.UserErasure{}, err
+	}
+	defer tx.Rollback()
+
+	var erased bool
+	query := `SELECT erased_at IS NOT NULL FROM public."user" WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRow(query, erasure.UserID).Scan(&erased)
+	if err == sql.ErrNoRows {
+		return models.UserErasure{}, errUserNotFound
+	}
+	if err != nil {
+		return models.UserErasure{}, err
+	}
+	if erased {
+		return models.UserErasure{}, errUserAlreadyErased
+	}
+
+	ids := pq.Array([]uuid.UUID{erasure.UserID})
+	for _, statement := range userCredentialStatements {
+		if _, err := tx.Exec(statement, ids); err != nil {
+			return models.UserErasure{}, err
+		}
+	}
+
+	query = `
+        UPDATE public."user" SET
+            first_name = $2,
+            last_name = $3,
+            username = 'erased-' || id || '@erased.invalid',
+            password = $4,
+            email_verified_at = NULL,
+            deleted_at = coalesce(deleted_at, NOW()),
+            erased_at = NOW(),
+            updated_at = NOW(),
+            version = version + 1
+        WHERE id = $1
+    `
+	// Nobody can sign in to an erased account again
+	if _, err := tx.Exec(query, erasure.UserID, erasedFirstName, erasedLastName, password.Unusable); err != nil {
+		return models.UserErasure{}, err
+	}
+
+	erasure.ID = uuid.New()
+	query = `
+        INSERT INTO public.user_erasure (id, user_id, requested_by, reason, erased_at)
+        VALUES ($1, $2, $3, $4, NOW())
+        RETURNING erased_at
+    `
+	err = tx.QueryRow(query, erasure.ID, erasure.UserID, erasure.RequestedBy, erasure.Reason).Scan(&erasure.ErasedAt)
+	if err != nil {
+		return models.UserErasure{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.UserErasure{}, err
+	}
+
+	log.Printf("Erased user %s", erasure.UserID)
+	return erasure, nil
+}
+
+// GetErasures returns the erasure records of a user
+func (er *ErasureRepository) GetErasures(userID uuid.UUID) ([]models.UserErasure, error) {
+	query := `
+        SELECT id, user_id, requested_by, reason, erased_at
+        FROM public.user_erasure
+        WHERE user_id = $1
+        ORDER BY erased_at
+    `
+	rows, err := er.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	erasures := []models.UserErasure{}
+	for rows.Next() {
+		var erasure models.UserErasure
+		if err := rows.Scan(&erasure.ID, &erasure.UserID, &erasure.RequestedBy, &erasure.Reason, &erasure.ErasedAt); err != nil {
+			return nil, err
+		}
+		erasures = append(erasures, erasure)
+	}
+	return erasures, rows.Err()
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"errors"
+	"github.com/lib/pq"
+)
+
+// Kinds of domain error returned by the repositories. Handlers map them to
+// HTTP statuses with errors.Is.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrValidation = errors.New("validation failed")
+	ErrForbidden  = errors.New("forbidden")
+	// ErrPreconditionFailed means a conditional write lost a race with another writer
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Error is a domain error carrying a message that is safe to show to clients.
+// It unwraps to its Kind.
+type Error struct {
+	Kind    error
+	Message string
+	// Fields holds per-field problems for ErrValidation errors
+	Fields map[string]string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// ErrDuplicateUsername is returned when a username is already taken by another user
+var ErrDuplicateUsername = &Error{Kind: ErrConflict, Message: "username already exists"}
+
+// errUserNotFound is returned for unknown and deleted users
+var errUserNotFound = &Error{Kind: ErrNotFound, Message: "user not found"}
+
+// errDeletedUserNotFound is returned when restoring a user that is not deleted or does not exist
+var errDeletedUserNotFound = &Error{Kind: ErrNotFound, Message: "deleted user not found"}
+
+// errVersionMismatch is returned when a versioned write finds a newer version stored
+var errVersionMismatch = &Error{Kind: ErrPreconditionFailed, Message: "user was modified by another request"}
+
+// pqUniqueViolation is the Postgres error code for unique_violation
+const pqUniqueViolation = "23505"
+
+// isUniqueViolation reports whether err is a unique violation of the named constraint or index
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation && pqErr.Constraint == constraint
+}
@@ -0,0 +1,454 @@
+package repository
+
+import (
+	"booking-service/models"
+	"database/sql"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"strings"
+)
+
+// groupNameKey is the unique index on group names within an organization
+const groupNameKey = "user_group_name_key"
+
+const groupColumns = `id, organization_id, name, description, permissions, booking_quota, created_at, updated_at`
+
+// userGroupIDs is a recursive query for the IDs of the groups user $1 belongs
+// to, directly or through nesting. UNION stops it even if groups form a cycle.
+const userGroupIDs = `
+        WITH RECURSIVE member_of(id) AS (
+            SELECT group_id FROM public.user_group_member WHERE user_id = $1
+            UNION
+            SELECT s.parent_id FROM public.user_group_subgroup s JOIN member_of m ON s.child_id = m.id
+        )
+        SELECT id FROM member_of`
+
+// ErrDuplicateGroupName is returned when the organization already has a group with the name
+var ErrDuplicateGroupName = &Error{Kind: ErrConflict, Message: "group name already exists"}
+
+var errGroupNotFound = &Error{Kind: ErrNotFound, Message: "group not found"}
+
+var errGroupMemberNotFound = &Error{Kind: ErrNotFound, Message: "group member not found"}
+
+var errSubgroupNotFound = &Error{Kind: ErrNotFound, Message: "subgroup not found"}
+
+var errGroupCycle = &Error{Kind: ErrConflict, Message: "a group cannot be nested inside itself"}
+
+// GroupRepository stores user groups. A repository scoped with ForTenant only
+// sees the groups of that organization and only adds its members to them.
+type GroupRepository struct {
+	db *sql.DB
+	tenantScope
+}
+
+func NewGroupRepository(db *sql.DB) *GroupRepository {
+	return &GroupRepository{db: db}
+}
+
+// ForTenant returns a repository limited to the organization's groups.
+// uuid.Nil leaves the repository unscoped.
+func (gr *GroupRepository) ForTenant(tenantID uuid.UUID) *GroupRepository {
+	return &GroupRepository{db: gr.db, tenantScope: tenantScope{tenantID: tenantID}}
+}
+
+func validateGroup(group models.Group) error {
+	fields := map[string]string{}
+	if strings.TrimSpace(group.Name) == "" {
+		fields["name"] = "is required"
+	} else if len(group.Name) > 255 {
+		fields["name"] = "must be at most 255 characters"
+	}
+	if group.BookingQuota != nil && *group.BookingQuota < 0 {
+		fields["booking_quota"] = "must not be negative"
+	}
+	if len(fields) > 0 {
+		return &Error{Kind: ErrValidation, Message: "invalid group", Fields: fields}
+	}
+	return nil
+}
+
+// InsertGroup creates a group in the repository's organization, or a global
+// group if the repository is unscoped
+func (gr *GroupRepository) InsertGroup(group models.Group) (models.Group, error) {
+	if err := validateGroup(group); err != nil {
+		return models.Group{}, err
+	}
+	if group.Permissions == nil {
+		group.Permissions = []string{}
+	}
+
+	query := `
+        INSERT INTO public.user_group (id, organization_id, name, description, permissions, booking_quota, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
+        RETURNING ` + groupColumns
+	organizationID := uuid.NullUUID{UUID: gr.tenantID, Valid: gr.tenantID != uuid.Nil}
+	group, err := scanGroup(gr.db.QueryRow(query, uuid.New(), organizationID, strings.TrimSpace(group.Name), group.Description, pq.Array(group.Permissions), group.BookingQuota))
+	if isUniqueViolation(err, groupNameKey) {
+		return models.Group{}, ErrDuplicateGroupName
+	}
+	if err != nil {
+		return models.Group{}, err
+	}
+	return group, nil
+}
+
+func (gr *GroupRepository) GetGroup(id uuid.UUID) (models.Group, error) {
+	tenant, args := gr.orgCondition("organization_id", []interface{}{id})
+	query := `SELECT ` + groupColumns + ` FROM public.user_group WHERE id = $1 AND ` + tenant
+	group, err := scanGroup(gr.db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return models.Group{}, errGroupNotFound
+	}
+	return group, err
+}
+
+// GetGroups returns the groups ordered by name
+func (gr *GroupRepository) GetGroups() ([]models.Group, error) {
+	tenant, args := gr.orgCondition("organization_id", nil)
+	query := `SELECT ` + groupColumns + ` FROM public.user_group WHERE ` + tenant + ` ORDER BY lower(name), id`
+	return gr.queryGroups(query, args...)
+}
+
+// UpdateGroup replaces the group's name, description, permissions and booking quota
+func (gr *GroupRepository) UpdateGroup(group models.Group) (models.Group, error) {
+	if err := validateGroup(group); err != nil {
+		return models.Group{}, err
+	}
+	if group.Permissions == nil {
+		group.Permissions = []string{}
+	}
+
+	tenant, args := gr.orgCondition("organization_id", []interface{}{group.ID, strings.TrimSpace(group.Name), group.Description, pq.Array(group.Permissions), group.BookingQuota})
+	query := `
+        UPDATE public.user_group SET name = $2, description = $3, permissions = $4, booking_quota = $5, updated_at = NOW()
+        WHERE id = $1 AND ` + tenant + `
+        RETURNING ` + groupColumns
+	group, err := scanGroup(gr.db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return models.Group{}, errGroupNotFound
+	}
+	if isUniqueViolation(err, groupNameKey) {
+		return models.Group{}, ErrDuplicateGroupName
+	}
+	if err != nil {
+		return models.Group{}, err
+	}
+	return group, nil
+}
+
+// DeleteGroup deletes the group along with its memberships and nesting
+func (gr *GroupRepository) DeleteGroup(id uuid.UUID) error {
+	tx, err := gr.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := gr.lockGroups(tx, id); err != nil {
+		return err
+	}
+	statements := []string{
+		`DELETE FROM public.user_group_member WHERE group_id = $1`,
+		`DELETE FROM public.user_group_subgroup WHERE parent_id = $1 OR child_id = $1`,
+		`DELETE FROM public.user_group WHERE id = $1`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AddGroupMember adds a live user to the group. In a scoped repository the
+// user must be a member of the organization.
+func (gr *GroupRepository) AddGroupMember(groupID, userID uuid.UUID) (models.GroupMember, error) {
+	if _, err := gr.GetGroup(groupID); err != nil {
+		return models.GroupMember{}, err
+	}
+
+	tenant, args := gr.userCondition("id", []interface{}{groupID, userID})
+	query := `
+        INSERT INTO public.user_group_member (group_id, user_id, created_at)
+        SELECT $1, id, NOW() FROM public."user" WHERE id = $2 AND deleted_at IS NULL AND ` + tenant + `
+        ON CONFLICT (group_id, user_id) DO UPDATE SET created_at = public.user_group_member.created_at
+        RETURNING group_id, user_id, created_at
+    `
+	var member models.GroupMember
+	err := gr.db.QueryRow(query, args...).Scan(&member.GroupID, &member.UserID, &member.CreatedAt)
+	if err == sql.ErrNoRows {
+		return models.GroupMember{}, errUserNotFound
+	}
+	if err != nil {
+		return models.GroupMember{}, err
+	}
+	return member, nil
+}
+
+func (gr *GroupRepository) RemoveGroupMember(groupID, userID uuid.UUID) error {
+	if _, err := gr.GetGroup(groupID); err != nil {
+		return err
+	}
+
+	query := `DELETE FROM public.user_group_member WHERE group_id = $1 AND user_id = $2`
+	result, err := gr.db.Exec(query, groupID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errGroupMemberNotFound
+	}
+	return nil
+}
+
+// GetGroupMembers returns the users added to the group directly, oldest first
+func (gr *GroupRepository) GetGroupMembers(groupID uuid.UUID) ([]models.GroupMember, error) {
+	if _, err := gr.GetGroup(groupID); err != nil {
+		return nil, err
+	}
+
+	query := `
+        SELECT group_id, user_id, created_at
+        FROM public.user_group_member
+        WHERE group_id = $1
+        ORDER BY created_at, user_id
+    `
+	rows, err := gr.db.Query(query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.GroupMember{}
+	for rows.Next() {
+		var member models.GroupMember
+		if err := rows.Scan(&member.GroupID, &member.UserID, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// AddSubgroup nests the child group inside the parent, so the child's members
+// also belong to the parent. Both groups must be in the same organization and
+// the nesting must not form a cycle.
+func (gr *GroupRepository) AddSubgroup(parentID, childID uuid.UUID) error {
+	if parentID == childID {
+		return errGroupCycle
+	}
+
+	tx, err := gr.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := gr.lockGroups(tx, parentID, childID); err != nil {
+		return err
+	}
+
+	var sameOrganization, cycle bool
+	query := `
+        WITH RECURSIVE descendants(id) AS (
+            SELECT child_id FROM public.user_group_subgroup WHERE parent_id = $2
+            UNION
+            SELECT s.child_id FROM public.user_group_subgroup s JOIN descendants d ON s.parent_id = d.id
+        )
+        SELECT
+            (SELECT organization_id FROM public.user_group WHERE id = $1) IS NOT DISTINCT FROM
+                (SELECT organization_id FROM public.user_group WHERE id = $2),
+            EXISTS (SELECT 1 FROM descendants WHERE id = $1)
+    `
+	if err := tx.QueryRow(query, parentID, childID).Scan(&sameOrganization, &cycle); err != nil {
+		return err
+	}
+	if !sameOrganization {
+		return &Error{Kind: ErrValidation, Message: "groups belong to different organizations"}
+	}
+	if cycle {
+		return errGroupCycle
+	}
+
+	query = `
+        INSERT INTO public.user_group_subgroup (parent_id, child_id, created_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (parent_id, child_id) DO NOTHING
+    `
+	if _, err := tx.Exec(query, parentID, childID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (gr *GroupRepository) RemoveSubgroup(parentID, childID uuid.UUID) error {
+	if _, err := gr.GetGroup(parentID); err != nil {
+		return err
+	}
+
+	query := `DELETE FROM public.user_group_subgroup WHERE parent_id = $1 AND child_id = $2`
+	result, err := gr.db.Exec(query, parentID, childID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errSubgroupNotFound
+	}
+	return nil
+}
+
+// GetSubgroups returns the groups nested directly inside the group
+func (gr *GroupRepository) GetSubgroups(parentID uuid.UUID) ([]models.Group, error) {
+	if _, err := gr.GetGroup(parentID); err != nil {
+		return nil, err
+	}
+
+	query := `
+        SELECT ` + groupColumns + `
+        FROM public.user_group
+        WHERE id IN (SELECT child_id FROM public.user_group_subgroup WHERE parent_id = $1)
+        ORDER BY lower(name), id
+    `
+	return gr.queryGroups(query, parentID)
+}
+
+// GetUserGroups returns the groups the user belongs to, directly or through
+// nesting. A scoped repository returns that organization's groups and the
+// global ones; an unscoped repository returns them all.
+func (gr *GroupRepository) GetUserGroups(userID uuid.UUID) ([]models.Group, error) {
+	args := []interface{}{userID}
+	tenant := "TRUE"
+	if gr.tenantID != uuid.Nil {
+		args = append(args, gr.tenantID)
+		tenant = fmt.Sprintf("(organization_id IS NULL OR organization_id = $%d)", len(args))
+	}
+	query := `
+        SELECT ` + groupColumns + `
+        FROM public.user_group
+        WHERE id IN (` + userGroupIDs + `) AND ` + tenant + `
+        ORDER BY lower(name), id
+    `
+	return gr.queryGroups(query, args...)
+}
+
+// GroupPermissions implements auth.GroupPermissionStore. Groups of other
+// organizations than the session's never grant anything.
+func (gr *GroupRepository) GroupPermissions(userID, tenantID uuid.UUID) ([]string, error) {
+	query := `
+        SELECT DISTINCT unnest(permissions)
+        FROM public.user_group
+        WHERE id IN (` + userGroupIDs + `)
+            AND (organization_id IS NULL OR organization_id = $2)
+    `
+	return gr.queryPermissions(query, userID, uuid.NullUUID{UUID: tenantID, Valid: tenantID != uuid.Nil})
+}
+
+// InheritedPermissions returns what joining the group grants: its own
+// permissions and those of every group it is nested inside
+func (gr *GroupRepository) InheritedPermissions(groupID uuid.UUID) ([]string, error) {
+	if _, err := gr.GetGroup(groupID); err != nil {
+		return nil, err
+	}
+
+	query := `
+        WITH RECURSIVE ancestors(id) AS (
+            SELECT $1::uuid
+            UNION
+            SELECT s.parent_id FROM public.user_group_subgroup s JOIN ancestors a ON s.child_id = a.id
+        )
+        SELECT DISTINCT unnest(permissions)
+        FROM public.user_group
+        WHERE id IN (SELECT id FROM ancestors)
+    `
+	return gr.queryPermissions(query, groupID)
+}
+
+func (gr *GroupRepository) queryPermissions(query string, args ...interface{}) ([]string, error) {
+	rows, err := gr.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []string
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+// lockGroups locks the groups for the rest of the transaction, failing if
+// any of them is not visible to the repository
+func (gr *GroupRepository) lockGroups(tx *sql.Tx, ids ...uuid.UUID) error {
+	tenant, args := gr.orgCondition("organization_id", []interface{}{pq.Array(ids)})
+	query := `SELECT count(*) FROM (SELECT id FROM public.user_group WHERE id = ANY($1) AND ` + tenant + ` FOR UPDATE) locked`
+	var locked int
+	if err := tx.QueryRow(query, args...).Scan(&locked); err != nil {
+		return err
+	}
+	if locked != len(ids) {
+		return errGroupNotFound
+	}
+	return nil
+}
+
+func (gr *GroupRepository) queryGroups(query string, args ...interface{}) ([]models.Group, error) {
+	rows, err := gr.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []models.Group{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+func scanGroup(row rowScanner) (models.Group, error) {
+	var group models.Group
+	var organizationID uuid.NullUUID
+	var bookingQuota sql.NullInt64
+	err := row.Scan(
+		&group.ID,
+		&organizationID,
+		&group.Name,
+		&group.Description,
+		pq.Array(&group.Permissions),
+		&bookingQuota,
+		&group.CreatedAt,
+		&group.UpdatedAt,
+	)
+	if err != nil {
+		return models.Group{}, err
+	}
+	if organizationID.Valid {
+		group.OrganizationID = &organizationID.UUID
+	}
+	if bookingQuota.Valid {
+		quota := int(bookingQuota.Int64)
+		group.BookingQuota = &quota
+	}
+	if group.Permissions == nil {
+		group.Permissions = []string{}
+	}
+	return group, nil
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"booking-service/models"
+	"database/sql"
+	"github.com/google/uuid"
+	"time"
+)
+
+// identityProviderSubjectKey allows one link per external subject
+const identityProviderSubjectKey = "user_identity_provider_subject_key"
+
+// ErrIdentityLinked is returned when the external subject is already linked to another user
+var ErrIdentityLinked = &Error{Kind: ErrConflict, Message: "identity is already linked to another user"}
+
+// OIDCLoginState is an in-flight OIDC login or identity link
+type OIDCLoginState struct {
+	Nonce        string
+	CodeVerifier string
+	// LinkUserID is the signed-in user linking an identity; uuid.Nil for logins
+	LinkUserID uuid.UUID
+}
+
+type IdentityRepository struct {
+	db *sql.DB
+}
+
+func NewIdentityRepository(db *sql.DB) *IdentityRepository {
+	return &IdentityRepository{db: db}
+}
+
+func (ir *IdentityRepository) GetIdentity(provider, subject string) (models.UserIdentity, error) {
+	query := `
+        SELECT id, user_id, provider, subject, coalesce(email, ''), created_at, last_login_at
+        FROM public.user_identity
+        WHERE provider = $1 AND subject = $2
+    `
+	var identity models.UserIdentity
+	err := ir.db.QueryRow(query, provider, subject).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+		&identity.LastLoginAt,
+	)
+	if err != nil {
+		return models.UserIdentity{}, err
+	}
+	return identity, nil
+}
+
+// GetIdentitiesByUser returns the external identities linked to the user
+func (ir *IdentityRepository) GetIdentitiesByUser(userID uuid.UUID) ([]models.UserIdentity, error) {
+	query := `
+        SELECT id, user_id, provider, subject, coalesce(email, ''), created_at, last_login_at
+        FROM public.user_identity
+        WHERE user_id = $1
+        ORDER BY created_at
+    `
+	rows, err := ir.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.UserIdentity{}
+	for rows.Next() {
+		var identity models.UserIdentity
+		err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+			&identity.LastLoginAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (ir *IdentityRepository) InsertIdentity(userID uuid.UUID, provider, subject, email string) (models.UserIdentity, error) {
+	query := `
+        INSERT INTO public.user_identity (id, user_id, provider, subject, email, created_at, last_login_at)
+        VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+        RETURNING id, user_id, provider, subject, coalesce(email, ''), created_at, last_login_at
+    `
+	var identity models.UserIdentity
+	err := ir.db.QueryRow(query, uuid.New(), userID, provider, subject, email).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+		&identity.LastLoginAt,
+	)
+	if isUniqueViolation(err, identityProviderSubjectKey) {
+		return models.UserIdentity{}, ErrIdentityLinked
+	}
+	if err != nil {
+		return models.UserIdentity{}, err
+	}
+	return identity, nil
+}
+
+func (ir *IdentityRepository) TouchIdentity(id uuid.UUID, email string) error {
+	query := `
+        UPDATE public.user_identity SET last_login_at = NOW(), email = $2 WHERE id = $1
+    `
+	_, err := ir.db.Exec(query, id, email)
+	return err
+}
+
+func (ir *IdentityRepository) InsertLoginState(state string, login OIDCLoginState, expiresAt time.Time) error {
+	query := `
+        INSERT INTO public.oidc_login_state (state, nonce, code_verifier, link_user_id, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+    `
+	linkUserID := uuid.NullUUID{UUID: login.LinkUserID, Valid: login.LinkUserID != uuid.Nil}
+	_, err := ir.db.Exec(query, state, login.Nonce, login.CodeVerifier, linkUserID, expiresAt)
+	return err
+}
+
+// ConsumeLoginState deletes the login state and returns it. It returns
+// sql.ErrNoRows for unknown or expired states.
+func (ir *IdentityRepository) ConsumeLoginState(state string) (OIDCLoginState, error) {
+	query := `
+        DELETE FROM public.oidc_login_state
+        WHERE state = $1
+        RETURNING nonce, code_verifier, link_user_id, expires_at
+    `
+	var login OIDCLoginState
+	var linkUserID uuid.NullUUID
+	var expiresAt time.Time
+	err := ir.db.QueryRow(query, state).Scan(&login.Nonce, &login.CodeVerifier, &linkUserID, &expiresAt)
+	if err != nil {
+		return OIDCLoginState{}, err
+	}
+	if time.Now().After(expiresAt) {
+		return OIDCLoginState{}, sql.ErrNoRows
+	}
+	login.LinkUserID = linkUserID.UUID
+	return login, nil
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"booking-service/models"
+	"database/sql"
+	"github.com/google/uuid"
+)
+
+// ImpersonationRepository stores impersonation sessions and implements auth.ImpersonationAuditor
+type ImpersonationRepository struct {
+	db *sql.DB
+	tenantScope
+}
+
+func NewImpersonationRepository(db *sql.DB) *ImpersonationRepository {
+	return &ImpersonationRepository{db: db}
+}
+
+// ForTenant returns a repository that only sees sessions acting in the
+// organization. uuid.Nil leaves the repository unscoped.
+func (ir *ImpersonationRepository) ForTenant(tenantID uuid.UUID) *ImpersonationRepository {
+	return &ImpersonationRepository{db: ir.db, tenantScope: tenantScope{tenantID: tenantID}}
+}
+
+func (ir *ImpersonationRepository) InsertSession(session models.ImpersonationSession) error {
+	query := `
+        INSERT INTO public.impersonation_session (jti, actor_id, subject_id, reason, allow_destructive, organization_id, expires_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+    `
+	_, err := ir.db.Exec(query, session.TokenID, session.ActorID, session.SubjectID, session.Reason, session.AllowDestructive, session.OrganizationID, session.ExpiresAt)
+	return err
+}
+
+func (ir *ImpersonationRepository) RecordImpersonatedRequest(req models.ImpersonatedRequest) error {
+	query := `
+        INSERT INTO public.impersonation_audit (id, jti, actor_id, subject_id, method, path, status, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `
+	_, err := ir.db.Exec(query, uuid.New(), req.TokenID, req.ActorID, req.SubjectID, req.Method, req.Path, req.Status, req.At)
+	return err
+}
+
+// GetSessions returns impersonation sessions, newest first, optionally for one subject
+func (ir *ImpersonationRepository) GetSessions(subjectID *uuid.UUID) ([]models.ImpersonationSession, error) {
+	tenant, args := ir.orgCondition("s.organization_id", []interface{}{subjectID})
+	query := `
+        SELECT s.jti, s.actor_id, s.subject_id, s.reason, s.allow_destructive, s.organization_id, s.expires_at, s.created_at,
+            (SELECT count(*) FROM public.impersonation_audit a WHERE a.jti = s.jti)
+        FROM public.impersonation_session s
+        WHERE ($1::uuid IS NULL OR s.subject_id = $1) AND ` + tenant + `
+        ORDER BY s.created_at DESC
+        LIMIT 500
+    `
+	rows, err := ir.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.ImpersonationSession{}
+	for rows.Next() {
+		var session models.ImpersonationSession
+		err := rows.Scan(
+			&session.TokenID,
+			&session.ActorID,
+			&session.SubjectID,
+			&session.Reason,
+			&session.AllowDestructive,
+			&session.OrganizationID,
+			&session.ExpiresAt,
+			&session.CreatedAt,
+			&session.RequestCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
@@ -0,0 +1,299 @@
+package repository
+
+import (
+	"booking-service/models"
+	"booking-service/password"
+	"database/sql"
+	"github.com/google/uuid"
+	"strings"
+)
+
+// invitationOpenEmailKey allows one open invitation per address and organization
+const invitationOpenEmailKey = "invitation_open_email_key"
+
+// invitationStatus derives an invitation's status from its timestamps
+const invitationStatus = `
+            CASE WHEN accepted_at IS NOT NULL THEN 'accepted'
+                 WHEN revoked_at IS NOT NULL THEN 'revoked'
+                 WHEN expires_at <= NOW() THEN 'expired'
+                 ELSE 'pending' END`
+
+const invitationColumns = `id, organization_id, email, role, ` + invitationStatus + `,
+            invited_by, user_id, expires_at, accepted_at, revoked_at, created_at`
+
+var errInvitationNotFound = &Error{Kind: ErrNotFound, Message: "invitation not found"}
+
+var errAlreadyMember = &Error{Kind: ErrConflict, Message: "user is already a member of the organization"}
+
+var errInvitationExists = &Error{Kind: ErrConflict, Message: "an invitation for this address is already open"}
+
+// InvitationAcceptance holds what the invitee provides when accepting
+type InvitationAcceptance struct {
+	FirstName string
+	LastName  string
+	// Password is the plaintext password for a new account; existing users keep theirs
+	Password string
+}
+
+type InvitationRepository struct {
+	db *sql.DB
+	tenantScope
+}
+
+func NewInvitationRepository(db *sql.DB) *InvitationRepository {
+	return &InvitationRepository{db: db}
+}
+
+// ForTenant returns a repository whose per-user listings only include the
+// organization's invitations. uuid.Nil leaves the repository unscoped.
+func (ir *InvitationRepository) ForTenant(tenantID uuid.UUID) *InvitationRepository {
+	return &InvitationRepository{db: ir.db, tenantScope: tenantScope{tenantID: tenantID}}
+}
+
+// InsertInvitation stores a pending invitation, revoking any earlier open
+// invitation for the same address and organization
+func (ir *InvitationRepository) InsertInvitation(invitation models.Invitation) (models.Invitation, error) {
+	invitation.ID = uuid.New()
+	invitation.Email = strings.ToLower(strings.TrimSpace(invitation.Email))
+
+	tx, err := ir.db.Begin()
+	if err != nil {
+		return models.Invitation{}, err
+	}
+	defer tx.Rollback()
+
+	var member bool
+	query := `
+        SELECT EXISTS (
+            SELECT 1 FROM public.organization_member m
+            JOIN public."user" u ON u.id = m.user_id
+            WHERE m.organization_id = $1 AND lower(u.username) = $2 AND u.deleted_at IS NULL
+        )
+    `
+	if err := tx.QueryRow(query, invitation.OrganizationID, invitation.Email).Scan(&member); err != nil {
+		return models.Invitation{}, err
+	}
+	if member {
+		return models.Invitation{}, errAlreadyMember
+	}
+
+	query = `
+        UPDATE public.invitation SET revoked_at = NOW()
+        WHERE organization_id = $1 AND lower(email) = $2 AND accepted_at IS NULL AND revoked_at IS NULL
+    `
+	if _, err := tx.Exec(query, invitation.OrganizationID, invitation.Email); err != nil {
+		return models.Invitation{}, err
+	}
+
+	query = `
+        INSERT INTO public.invitation (id, organization_id, email, role, invited_by, expires_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, NOW())
+        RETURNING ` + invitationColumns
+	invitation, err = scanInvitation(tx.QueryRow(query, invitation.ID, invitation.OrganizationID, invitation.Email, invitation.Role, invitation.InvitedBy, invitation.ExpiresAt))
+	if isUniqueViolation(err, invitationOpenEmailKey) {
+		return models.Invitation{}, errInvitationExists
+	}
+	if err != nil {
+		return models.Invitation{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Invitation{}, err
+	}
+	return invitation, nil
+}
+
+func (ir *InvitationRepository) GetInvitation(id uuid.UUID) (models.Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM public.invitation WHERE id = $1`
+	invitation, err := scanInvitation(ir.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return models.Invitation{}, errInvitationNotFound
+	}
+	return invitation, err
+}
+
+// GetInvitations returns the organization's invitations, newest first,
+// optionally only those with the given status
+func (ir *InvitationRepository) GetInvitations(orgID uuid.UUID, status string) ([]models.Invitation, error) {
+	query := `
+        SELECT ` + invitationColumns + `
+        FROM public.invitation
+        WHERE organization_id = $1 AND ($2 = '' OR ` + invitationStatus + ` = $2)
+        ORDER BY created_at DESC
+        LIMIT 500
+    `
+	rows, err := ir.db.Query(query, orgID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []models.Invitation{}
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
+
+// GetInvitationsByUser returns the invitations the user accepted or that were
+// sent to their address, newest first
+func (ir *InvitationRepository) GetInvitationsByUser(userID uuid.UUID, email string) ([]models.Invitation, error) {
+	tenant, args := ir.orgCondition("organization_id", []interface{}{userID, email})
+	query := `
+        SELECT ` + invitationColumns + `
+        FROM public.invitation
+        WHERE (user_id = $1 OR lower(email) = lower($2)) AND ` + tenant + `
+        ORDER BY created_at DESC
+    `
+	rows, err := ir.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []models.Invitation{}
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
+
+// RevokeInvitation stops an invitation of the organization from being
+// accepted. Accepted invitations cannot be revoked.
+func (ir *InvitationRepository) RevokeInvitation(orgID, id uuid.UUID) (models.Invitation, error) {
+	query := `
+        UPDATE public.invitation SET revoked_at = NOW()
+        WHERE id = $1 AND organization_id = $2 AND accepted_at IS NULL AND revoked_at IS NULL
+        RETURNING ` + invitationColumns
+	invitation, err := scanInvitation(ir.db.QueryRow(query, id, orgID))
+	if err != sql.ErrNoRows {
+		return invitation, err
+	}
+
+	// Nothing was revoked: the invitation is unknown or already closed
+	invitation, err = ir.GetInvitation(id)
+	if err != nil || invitation.OrganizationID != orgID {
+		return models.Invitation{}, errInvitationNotFound
+	}
+	return models.Invitation{}, &Error{Kind: ErrConflict, Message: "invitation is already " + invitation.Status}
+}
+
+// AcceptInvitation uses a pending invitation in one transaction. If no live
+// user has the invited address one is created with the given names and
+// password and its email marked verified, since the invitee proved they read
+// it. The user joins the organization with the invited role unless already a
+// member. It returns the accepted invitation and the user.
+func (ir *InvitationRepository) AcceptInvitation(id uuid.UUID, acceptance InvitationAcceptance) (models.Invitation, models.User, error) {
+	tx, err := ir.db.Begin()
+	if err != nil {
+		return models.Invitation{}, models.User{}, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + invitationColumns + ` FROM public.invitation WHERE id = $1 FOR UPDATE`
+	invitation, err := scanInvitation(tx.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return models.Invitation{}, models.User{}, errInvitationNotFound
+	}
+	if err != nil {
+		return models.Invitation{}, models.User{}, err
+	}
+	if invitation.Status != models.InvitationPending {
+		return models.Invitation{}, models.User{}, &Error{Kind: ErrConflict, Message: "invitation is " + invitation.Status}
+	}
+
+	query = `
+        SELECT id, first_name, last_name, role, lower(username), created_at, updated_at, deleted_at, email_verified_at, version
+        FROM public."user"
+        WHERE lower(username) = $1 AND deleted_at IS NULL
+        FOR UPDATE
+    `
+	user, err := scanInvitedUser(tx.QueryRow(query, invitation.Email))
+	if err == sql.ErrNoRows {
+		if acceptance.Password == "" {
+			return models.Invitation{}, models.User{}, &Error{Kind: ErrValidation, Message: "invalid invitation acceptance", Fields: map[string]string{"password": "is required for a new account"}}
+		}
+		var hashedPassword string
+		if hashedPassword, err = password.Hash(acceptance.Password); err != nil {
+			return models.Invitation{}, models.User{}, err
+		}
+		query = `
+            INSERT INTO public."user" (id, first_name, last_name, password, role, username, created_at, updated_at, email_verified_at)
+            VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW(), NOW())
+            RETURNING id, first_name, last_name, role, lower(username), created_at, updated_at, deleted_at, email_verified_at, version
+        `
+		user, err = scanInvitedUser(tx.QueryRow(query, uuid.New(), acceptance.FirstName, acceptance.LastName, hashedPassword, models.RoleCustomer, invitation.Email))
+		if isUniqueViolation(err, usernameUniqueIndex) {
+			return models.Invitation{}, models.User{}, ErrDuplicateUsername
+		}
+	}
+	if err != nil {
+		return models.Invitation{}, models.User{}, err
+	}
+
+	query = `
+        INSERT INTO public.organization_member (organization_id, user_id, role, created_at)
+        VALUES ($1, $2, $3, NOW())
+        ON CONFLICT (organization_id, user_id) DO NOTHING
+    `
+	if _, err := tx.Exec(query, invitation.OrganizationID, user.ID, invitation.Role); err != nil {
+		return models.Invitation{}, models.User{}, err
+	}
+
+	query = `
+        UPDATE public.invitation SET accepted_at = NOW(), user_id = $2
+        WHERE id = $1
+        RETURNING ` + invitationColumns
+	invitation, err = scanInvitation(tx.QueryRow(query, id, user.ID))
+	if err != nil {
+		return models.Invitation{}, models.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Invitation{}, models.User{}, err
+	}
+	return invitation, user, nil
+}
+
+func scanInvitation(row rowScanner) (models.Invitation, error) {
+	var invitation models.Invitation
+	err := row.Scan(
+		&invitation.ID,
+		&invitation.OrganizationID,
+		&invitation.Email,
+		&invitation.Role,
+		&invitation.Status,
+		&invitation.InvitedBy,
+		&invitation.UserID,
+		&invitation.ExpiresAt,
+		&invitation.AcceptedAt,
+		&invitation.RevokedAt,
+		&invitation.CreatedAt,
+	)
+	return invitation, err
+}
+
+func scanInvitedUser(row rowScanner) (models.User, error) {
+	var user models.User
+	err := row.Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Role,
+		&user.Username,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.DeletedAt,
+		&user.EmailVerifiedAt,
+		&user.Version,
+	)
+	return user, err
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"booking-service/models"
+	"database/sql"
+	"time"
+)
+
+type LoginAttemptRepository struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptRepository(db *sql.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+// GetLockedUntil returns when the counter's lockout ends, or nil if it is not locked
+func (lr *LoginAttemptRepository) GetLockedUntil(kind, key string) (*time.Time, error) {
+	query := `
+        SELECT locked_until FROM public.login_attempt
+        WHERE kind = $1 AND key = $2 AND locked_until > NOW()
+    `
+	var lockedUntil *time.Time
+	err := lr.db.QueryRow(query, kind, key).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return lockedUntil, nil
+}
+
+// RecordFailure counts a failed login and returns the consecutive failure count.
+// Failures older than window are forgotten.
+func (lr *LoginAttemptRepository) RecordFailure(kind, key string, window time.Duration) (int, error) {
+	query := `
+        INSERT INTO public.login_attempt (kind, key, failed_count, last_failed_at)
+        VALUES ($1, $2, 1, NOW())
+        ON CONFLICT (kind, key) DO UPDATE SET
+            failed_count = CASE
+                WHEN login_attempt.last_failed_at < NOW() - make_interval(secs => $3) THEN 1
+                ELSE login_attempt.failed_count + 1
+            END,
+            last_failed_at = NOW()
+        RETURNING failed_count
+    `
+	var failedCount int
+	err := lr.db.QueryRow(query, kind, key, window.Seconds()).Scan(&failedCount)
+	if err != nil {
+		return 0, err
+	}
+	return failedCount, nil
+}
+
+func (lr *LoginAttemptRepository) Lock(kind, key string, until time.Time) error {
+	query := `
+        UPDATE public.login_attempt SET locked_until = $3 WHERE kind = $1 AND key = $2
+    `
+	_, err := lr.db.Exec(query, kind, key, until)
+	return err
+}
+
+// Reset clears the failures and any lockout for the counter
+func (lr *LoginAttemptRepository) Reset(kind, key string) (bool, error) {
+	result, err := lr.db.Exec(`DELETE FROM public.login_attempt WHERE kind = $1 AND key = $2`, kind, key)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// GetAttempt returns the failure counter of the kind and key. It returns
+// sql.ErrNoRows when nothing has failed for it.
+func (lr *LoginAttemptRepository) GetAttempt(kind, key string) (models.LoginAttempt, error) {
+	query := `
+        SELECT kind, key, failed_count, last_failed_at, locked_until
+        FROM public.login_attempt
+        WHERE kind = $1 AND key = $2
+    `
+	var attempt models.LoginAttempt
+	err := lr.db.QueryRow(query, kind, key).Scan(
+		&attempt.Kind,
+		&attempt.Key,
+		&attempt.FailedCount,
+		&attempt.LastFailedAt,
+		&attempt.LockedUntil,
+	)
+	if err != nil {
+		return models.LoginAttempt{}, err
+	}
+	return attempt, nil
+}
+
+// GetActiveLockouts returns every counter that is currently locked
+func (lr *LoginAttemptRepository) GetActiveLockouts() ([]models.LoginAttempt, error) {
+	query := `
+        SELECT kind, key, failed_count, last_failed_at, locked_until
+        FROM public.login_attempt
+        WHERE locked_until > NOW()
+        ORDER BY locked_until DESC
+    `
+	rows, err := lr.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		var attempt models.LoginAttempt
+		err := rows.Scan(
+			&attempt.Kind,
+			&attempt.Key,
+			&attempt.FailedCount,
+			&attempt.LastFailedAt,
+			&attempt.LockedUntil,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
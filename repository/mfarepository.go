@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"booking-service/models"
+	"database/sql"
+	"github.com/google/uuid"
+)
+
+type MFARepository struct {
+	db *sql.DB
+}
+
+func NewMFARepository(db *sql.DB) *MFARepository {
+	return &MFARepository{db: db}
+}
+
+func (mr *MFARepository) GetMFA(userID uuid.UUID) (models.UserMFA, error) {
+	query := `
+        SELECT user_id, secret, enabled_at, last_used_step, created_at
+        FROM public.user_mfa
+        WHERE user_id = $1
+    `
+	var mfa models.UserMFA
+	err := mr.db.QueryRow(query, userID).Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&mfa.EnabledAt,
+		&mfa.LastUsedStep,
+		&mfa.CreatedAt,
+	)
+	if err != nil {
+		return models.UserMFA{}, err
+	}
+	return mfa, nil
+}
+
+// SavePendingSecret starts or restarts enrollment. An enabled secret is never replaced.
+func (mr *MFARepository) SavePendingSecret(userID uuid.UUID, secret string) (bool, error) {
+	query := `
+        INSERT INTO public.user_mfa (user_id, secret, created_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+        WHERE user_mfa.enabled_at IS NULL
+    `
+	result, err := mr.db.Exec(query, userID, secret)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// UseStep records that the code for step was used. It returns false if that
+// step or a later one was already used, so a code cannot be replayed.
+func (mr *MFARepository) UseStep(userID uuid.UUID, step int64) (bool, error) {
+	query := `
+        UPDATE public.user_mfa SET last_used_step = $2
+        WHERE user_id = $1 AND last_used_step < $2
+    `
+	result, err := mr.db.Exec(query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// EnableMFA activates the pending secret and replaces any recovery codes
+func (mr *MFARepository) EnableMFA(userID uuid.UUID, recoveryCodeHashes []string) error {
+	tx, err := mr.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE public.user_mfa SET enabled_at = NOW() WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM public.mfa_recovery_code WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		query := `
+            INSERT INTO public.mfa_recovery_code (id, user_id, code_hash, created_at)
+            VALUES ($1, $2, $3, NOW())
+        `
+		if _, err := tx.Exec(query, uuid.New(), userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableMFA removes the secret and every recovery code
+func (mr *MFARepository) DisableMFA(userID uuid.UUID) error {
+	tx, err := mr.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM public.mfa_recovery_code WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM public.user_mfa WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode consumes an unused recovery code, returning false if none matches
+func (mr *MFARepository) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	query := `
+        UPDATE public.mfa_recovery_code SET used_at = NOW()
+        WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+    `
+	result, err := mr.db.Exec(query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
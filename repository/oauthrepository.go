@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"booking-service/models"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type OAuthRepository struct {
+	db *sql.DB
+	tenantScope
+}
+
+func NewOAuthRepository(db *sql.DB) *OAuthRepository {
+	return &OAuthRepository{db: db}
+}
+
+// ForTenant returns a repository that only lists consents given to clients of
+// the organization. uuid.Nil leaves the repository unscoped.
+func (oa *OAuthRepository) ForTenant(tenantID uuid.UUID) *OAuthRepository {
+	return &OAuthRepository{db: oa.db, tenantScope: tenantScope{tenantID: tenantID}}
+}
+
+func (oa *OAuthRepository) InsertClient(client models.OAuthClient) (models.OAuthClient, error) {
+	var secretHash interface{}
+	if client.SecretHash != "" {
+		secretHash = client.SecretHash
+	}
+
+	query := `
+        INSERT INTO public.oauth_client (id, secret_hash, name, redirect_uris, scopes, grant_types, organization_id, created_by, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+        RETURNING created_at
+    `
+	err := oa.db.QueryRow(query,
+		client.ID,
+		secretHash,
+		client.Name,
+		pq.Array(client.RedirectURIs),
+		pq.Array(client.Scopes),
+		pq.Array(client.GrantTypes),
+		client.OrganizationID,
+		client.CreatedBy,
+	).Scan(&client.CreatedAt)
+	if err != nil {
+		return models.OAuthClient{}, err
+	}
+	return client, nil
+}
+
+// GetClient returns an active (not revoked) client
+func (oa *OAuthRepository) GetClient(id string) (models.OAuthClient, error) {
+	query := `
+        SELECT id, coalesce(secret_hash, ''), name, redirect_uris, scopes, grant_types, organization_id, created_by, created_at, revoked_at
+        FROM public.oauth_client
+        WHERE id = $1 AND revoked_at IS NULL
+    `
+	return scanClient(oa.db.QueryRow(query, id))
+}
+
+func (oa *OAuthRepository) GetAllClients() ([]models.OAuthClient, error) {
+	query := `
+        SELECT id, coalesce(secret_hash, ''), name, redirect_uris, scopes, grant_types, organization_id, created_by, created_at, revoked_at
+        FROM public.oauth_client
+        ORDER BY created_at DESC
+    `
+	rows, err := oa.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []models.OAuthClient{}
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+func (oa *OAuthRepository) RevokeClient(id string) (bool, error) {
+	result, err := oa.db.Exec(`UPDATE public.oauth_client SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (oa *OAuthRepository) InsertAuthorizationCode(code models.OAuthAuthorizationCode) error {
+	query := `
+        INSERT INTO public.oauth_authorization_code (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `
+	_, err := oa.db.Exec(query, code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, pq.Array(code.Scopes), code.CodeChallenge, code.ExpiresAt)
+	return err
+}
+
+// ConsumeAuthorizationCode locks an unexpired, unused code, passes it to
+// validate and marks it used only if validate returns nil, so a request that
+// fails validation cannot burn a code it was never issued. It returns
+// sql.ErrNoRows for unknown, expired or already used codes.
+func (oa *OAuthRepository) ConsumeAuthorizationCode(codeHash string, validate func(models.OAuthAuthorizationCode) error) (models.OAuthAuthorizationCode, error) {
+	tx, err := oa.db.Begin()
+	if err != nil {
+		return models.OAuthAuthorizationCode{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at
+        FROM public.oauth_authorization_code
+        WHERE code_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+        FOR UPDATE
+    `
+	var code models.OAuthAuthorizationCode
+	err = tx.QueryRow(query, codeHash).Scan(
+		&code.CodeHash,
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		pq.Array(&code.Scopes),
+		&code.CodeChallenge,
+		&code.ExpiresAt,
+	)
+	if err != nil {
+		return models.OAuthAuthorizationCode{}, err
+	}
+	if err := validate(code); err != nil {
+		return models.OAuthAuthorizationCode{}, err
+	}
+
+	if _, err := tx.Exec(`UPDATE public.oauth_authorization_code SET used_at = NOW() WHERE code_hash = $1`, codeHash); err != nil {
+		return models.OAuthAuthorizationCode{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.OAuthAuthorizationCode{}, err
+	}
+	return code, nil
+}
+
+// GetConsent returns the scopes the user has granted the client
+func (oa *OAuthRepository) GetConsent(userID uuid.UUID, clientID string) (models.OAuthConsent, error) {
+	query := `
+        SELECT user_id, client_id, scopes, granted_at
+        FROM public.oauth_consent
+        WHERE user_id = $1 AND client_id = $2
+    `
+	var consent models.OAuthConsent
+	err := oa.db.QueryRow(query, userID, clientID).Scan(
+		&consent.UserID,
+		&consent.ClientID,
+		pq.Array(&consent.Scopes),
+		&consent.GrantedAt,
+	)
+	if err != nil {
+		return models.OAuthConsent{}, err
+	}
+	return consent, nil
+}
+
+// SaveConsent records consent, adding the scopes to any the user granted before
+func (oa *OAuthRepository) SaveConsent(userID uuid.UUID, clientID string, scopes []string) error {
+	query := `
+        INSERT INTO public.oauth_consent (user_id, client_id, scopes, granted_at)
+        VALUES ($1, $2, $3, NOW())
+        ON CONFLICT (user_id, client_id) DO UPDATE SET
+            scopes = ARRAY(SELECT DISTINCT unnest(oauth_consent.scopes || EXCLUDED.scopes)),
+            granted_at = NOW()
+    `
+	_, err := oa.db.Exec(query, userID, clientID, pq.Array(scopes))
+	return err
+}
+
+func (oa *OAuthRepository) GetConsentsByUser(userID uuid.UUID) ([]models.OAuthConsent, error) {
+	tenant, args := oa.orgCondition("c.organization_id", []interface{}{userID})
+	query := `
+        SELECT k.user_id, k.client_id, k.scopes, k.granted_at
+        FROM public.oauth_consent k
+        JOIN public.oauth_client c ON c.id = k.client_id
+        WHERE k.user_id = $1 AND ` + tenant + `
+        ORDER BY k.granted_at DESC
+    `
+	rows, err := oa.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	consents := []models.OAuthConsent{}
+	for rows.Next() {
+		var consent models.OAuthConsent
+		if err := rows.Scan(&consent.UserID, &consent.ClientID, pq.Array(&consent.Scopes), &consent.GrantedAt); err != nil {
+			return nil, err
+		}
+		consents = append(consents, consent)
+	}
+	return consents, rows.Err()
+}
+
+func (oa *OAuthRepository) DeleteConsent(userID uuid.UUID, clientID string) (bool, error) {
+	result, err := oa.db.Exec(`DELETE FROM public.oauth_consent WHERE user_id = $1 AND client_id = $2`, userID, clientID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanClient(row rowScanner) (models.OAuthClient, error) {
+	var client models.OAuthClient
+	err := row.Scan(
+		&client.ID,
+		&client.SecretHash,
+		&client.Name,
+		pq.Array(&client.RedirectURIs),
+		pq.Array(&client.Scopes),
+		pq.Array(&client.GrantTypes),
+		&client.OrganizationID,
+		&client.CreatedBy,
+		&client.CreatedAt,
+		&client.RevokedAt,
+	)
+	if err != nil {
+		return models.OAuthClient{}, err
+	}
+	return client, nil
+}
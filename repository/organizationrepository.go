@@ -0,0 +1,317 @@
+package repository
+
+import (
+	"booking-service/models"
+	"database/sql"
+	"github.com/google/uuid"
+	"regexp"
+	"strings"
+)
+
+// organizationSlugKey is the unique constraint on organization slugs
+const organizationSlugKey = "organization_slug_key"
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ErrDuplicateSlug is returned when another organization already uses the slug
+var ErrDuplicateSlug = &Error{Kind: ErrConflict, Message: "organization slug already exists"}
+
+var errOrganizationNotFound = &Error{Kind: ErrNotFound, Message: "organization not found"}
+
+var errMemberNotFound = &Error{Kind: ErrNotFound, Message: "organization member not found"}
+
+// errLastOwner is returned when a change would leave an organization without an owner
+var errLastOwner = &Error{Kind: ErrConflict, Message: "organization must keep at least one owner"}
+
+type OrganizationRepository struct {
+	db *sql.DB
+	tenantScope
+}
+
+func NewOrganizationRepository(db *sql.DB) *OrganizationRepository {
+	return &OrganizationRepository{db: db}
+}
+
+// ForTenant returns a repository whose membership listings only include the
+// organization. uuid.Nil leaves the repository unscoped.
+func (or *OrganizationRepository) ForTenant(tenantID uuid.UUID) *OrganizationRepository {
+	return &OrganizationRepository{db: or.db, tenantScope: tenantScope{tenantID: tenantID}}
+}
+
+func validateOrganization(org models.Organization) error {
+	fields := map[string]string{}
+	if strings.TrimSpace(org.Name) == "" {
+		fields["name"] = "is required"
+	} else if len(org.Name) > 255 {
+		fields["name"] = "must be at most 255 characters"
+	}
+	if !slugPattern.MatchString(org.Slug) || len(org.Slug) > 63 {
+		fields["slug"] = "must be 1-63 lowercase letters, digits and single hyphens"
+	}
+	if len(fields) > 0 {
+		return &Error{Kind: ErrValidation, Message: "invalid organization", Fields: fields}
+	}
+	return nil
+}
+
+// InsertOrganization creates the organization and, unless ownerID is
+// uuid.Nil, makes that user its owner in the same transaction
+func (or *OrganizationRepository) InsertOrganization(org models.Organization, ownerID uuid.UUID) (models.Organization, error) {
+	org.Slug = strings.ToLower(strings.TrimSpace(org.Slug))
+	if err := validateOrganization(org); err != nil {
+		return models.Organization{}, err
+	}
+	org.ID = uuid.New()
+
+	tx, err := or.db.Begin()
+	if err != nil {
+		return models.Organization{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO public.organization (id, name, slug, created_at)
+        VALUES ($1, $2, $3, NOW())
+        RETURNING created_at
+    `
+	err = tx.QueryRow(query, org.ID, strings.TrimSpace(org.Name), org.Slug).Scan(&org.CreatedAt)
+	if isUniqueViolation(err, organizationSlugKey) {
+		return models.Organization{}, ErrDuplicateSlug
+	}
+	if err != nil {
+		return models.Organization{}, err
+	}
+
+	if ownerID != uuid.Nil {
+		if _, err := upsertMember(tx, models.OrganizationMember{OrganizationID: org.ID, UserID: ownerID, Role: models.OrgRoleOwner}); err != nil {
+			return models.Organization{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Organization{}, err
+	}
+	return org, nil
+}
+
+func (or *OrganizationRepository) GetOrganization(id uuid.UUID) (models.Organization, error) {
+	query := `SELECT id, name, slug, created_at FROM public.organization WHERE id = $1`
+
+	var org models.Organization
+	err := or.db.QueryRow(query, id).Scan(&org.ID, &org.Name, &org.Slug, &org.CreatedAt)
+	if err == sql.ErrNoRows {
+		return models.Organization{}, errOrganizationNotFound
+	}
+	if err != nil {
+		return models.Organization{}, err
+	}
+	return org, nil
+}
+
+// GetOrganizations returns every organization ordered by name
+func (or *OrganizationRepository) GetOrganizations() ([]models.Organization, error) {
+	query := `SELECT id, name, slug, created_at FROM public.organization ORDER BY name, id`
+	rows, err := or.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []models.Organization{}
+	for rows.Next() {
+		var org models.Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.Slug, &org.CreatedAt); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+// AddMember adds a live user to the organization, or changes the role of an
+// existing member
+func (or *OrganizationRepository) AddMember(member models.OrganizationMember) (models.OrganizationMember, error) {
+	if !models.IsOrgRole(member.Role) {
+		return models.OrganizationMember{}, &Error{Kind: ErrValidation, Message: "invalid organization member", Fields: map[string]string{"role": "is not a known organization role"}}
+	}
+
+	tx, err := or.db.Begin()
+	if err != nil {
+		return models.OrganizationMember{}, err
+	}
+	defer tx.Rollback()
+
+	if err := lockOrganization(tx, member.OrganizationID); err != nil {
+		return models.OrganizationMember{}, err
+	}
+	if member.Role != models.OrgRoleOwner {
+		if err := checkNotLastOwner(tx, member.OrganizationID, member.UserID); err != nil {
+			return models.OrganizationMember{}, err
+		}
+	}
+	member, err = upsertMember(tx, member)
+	if err != nil {
+		return models.OrganizationMember{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.OrganizationMember{}, err
+	}
+	return member, nil
+}
+
+// RemoveMember removes the user from the organization. The last owner cannot be removed.
+func (or *OrganizationRepository) RemoveMember(orgID, userID uuid.UUID) error {
+	tx, err := or.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockOrganization(tx, orgID); err != nil {
+		return err
+	}
+	if err := checkNotLastOwner(tx, orgID, userID); err != nil {
+		return err
+	}
+
+	query := `DELETE FROM public.organization_member WHERE organization_id = $1 AND user_id = $2`
+	result, err := tx.Exec(query, orgID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errMemberNotFound
+	}
+	return tx.Commit()
+}
+
+// GetMembers returns the members of the organization, oldest first
+func (or *OrganizationRepository) GetMembers(orgID uuid.UUID) ([]models.OrganizationMember, error) {
+	query := `
+        SELECT organization_id, user_id, role, created_at
+        FROM public.organization_member
+        WHERE organization_id = $1
+        ORDER BY created_at, user_id
+    `
+	rows, err := or.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.OrganizationMember{}
+	for rows.Next() {
+		var member models.OrganizationMember
+		if err := rows.Scan(&member.OrganizationID, &member.UserID, &member.Role, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (or *OrganizationRepository) GetMembership(orgID, userID uuid.UUID) (models.OrganizationMember, error) {
+	query := `
+        SELECT m.organization_id, m.user_id, m.role, m.created_at, o.name
+        FROM public.organization_member m
+        JOIN public.organization o ON o.id = m.organization_id
+        WHERE m.organization_id = $1 AND m.user_id = $2
+    `
+
+	var member models.OrganizationMember
+	err := or.db.QueryRow(query, orgID, userID).Scan(&member.OrganizationID, &member.UserID, &member.Role, &member.CreatedAt, &member.OrganizationName)
+	if err == sql.ErrNoRows {
+		return models.OrganizationMember{}, errMemberNotFound
+	}
+	if err != nil {
+		return models.OrganizationMember{}, err
+	}
+	return member, nil
+}
+
+// GetMembershipsByUser returns the organizations the user belongs to, in the
+// order they joined them
+func (or *OrganizationRepository) GetMembershipsByUser(userID uuid.UUID) ([]models.OrganizationMember, error) {
+	tenant, args := or.orgCondition("m.organization_id", []interface{}{userID})
+	query := `
+        SELECT m.organization_id, m.user_id, m.role, m.created_at, o.name
+        FROM public.organization_member m
+        JOIN public.organization o ON o.id = m.organization_id
+        WHERE m.user_id = $1 AND ` + tenant + `
+        ORDER BY m.created_at, m.organization_id
+    `
+	rows, err := or.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.OrganizationMember{}
+	for rows.Next() {
+		var member models.OrganizationMember
+		if err := rows.Scan(&member.OrganizationID, &member.UserID, &member.Role, &member.CreatedAt, &member.OrganizationName); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// lockOrganization serializes membership changes of the organization so the
+// last-owner check cannot race
+func lockOrganization(tx *sql.Tx, orgID uuid.UUID) error {
+	var id uuid.UUID
+	err := tx.QueryRow(`SELECT id FROM public.organization WHERE id = $1 FOR UPDATE`, orgID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return errOrganizationNotFound
+	}
+	return err
+}
+
+// checkNotLastOwner fails if the user is the organization's only owner
+func checkNotLastOwner(tx *sql.Tx, orgID, userID uuid.UUID) error {
+	query := `
+        SELECT m.role = $3 AND (
+            SELECT count(*) FROM public.organization_member
+            WHERE organization_id = $1 AND role = $3
+        ) = 1
+        FROM public.organization_member m
+        WHERE m.organization_id = $1 AND m.user_id = $2
+    `
+	var last bool
+	err := tx.QueryRow(query, orgID, userID, models.OrgRoleOwner).Scan(&last)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if last {
+		return errLastOwner
+	}
+	return nil
+}
+
+// upsertMember inserts the membership or updates its role, provided the user
+// exists and is not deleted
+func upsertMember(tx *sql.Tx, member models.OrganizationMember) (models.OrganizationMember, error) {
+	query := `
+        INSERT INTO public.organization_member (organization_id, user_id, role, created_at)
+        SELECT $1, id, $3, NOW() FROM public."user" WHERE id = $2 AND deleted_at IS NULL
+        ON CONFLICT (organization_id, user_id) DO UPDATE SET role = EXCLUDED.role
+        RETURNING created_at
+    `
+	err := tx.QueryRow(query, member.OrganizationID, member.UserID, member.Role).Scan(&member.CreatedAt)
+	if err == sql.ErrNoRows {
+		return models.OrganizationMember{}, errUserNotFound
+	}
+	if err != nil {
+		return models.OrganizationMember{}, err
+	}
+	return member, nil
+}
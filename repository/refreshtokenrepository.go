@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"booking-service/models"
+	"database/sql"
+	"github.com/google/uuid"
+	"time"
+)
+
+type RefreshTokenRepository struct {
+	db *sql.DB
+	tenantScope
+}
+
+func NewRefreshTokenRepository(db *sql.DB) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+// ForTenant returns a repository that only lists sessions acting in the
+// organization. uuid.Nil leaves the repository unscoped.
+func (rr *RefreshTokenRepository) ForTenant(tenantID uuid.UUID) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: rr.db, tenantScope: tenantScope{tenantID: tenantID}}
+}
+
+// InsertRefreshToken stores a refresh token; tenantID is uuid.Nil for sessions
+// outside any organization
+func (rr *RefreshTokenRepository) InsertRefreshToken(userID, familyID, tenantID uuid.UUID, tokenHash string, expiresAt time.Time) (models.RefreshToken, error) {
+	token := models.RefreshToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TenantID:  tenantID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+	}
+
+	query := `
+        INSERT INTO public.refresh_token (id, user_id, family_id, organization_id, token_hash, expires_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, NOW())
+        RETURNING created_at
+    `
+	organizationID := uuid.NullUUID{UUID: tenantID, Valid: tenantID != uuid.Nil}
+	err := rr.db.QueryRow(query, token.ID, userID, familyID, organizationID, tokenHash, expiresAt).Scan(&token.CreatedAt)
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+
+	return token, nil
+}
+
+func (rr *RefreshTokenRepository) GetRefreshTokenByHash(tokenHash string) (models.RefreshToken, error) {
+	query := `
+        SELECT id, user_id, family_id, organization_id, token_hash, expires_at, created_at, used_at, revoked_at
+        FROM public.refresh_token
+        WHERE token_hash = $1
+    `
+
+	var token models.RefreshToken
+	var organizationID uuid.NullUUID
+	err := rr.db.QueryRow(query, tokenHash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&organizationID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+	)
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+	token.TenantID = organizationID.UUID
+
+	return token, nil
+}
+
+// GetRefreshTokensByUser returns every refresh token issued to the user, newest first
+func (rr *RefreshTokenRepository) GetRefreshTokensByUser(userID uuid.UUID) ([]models.RefreshToken, error) {
+	tenant, args := rr.orgCondition("organization_id", []interface{}{userID})
+	query := `
+        SELECT id, user_id, family_id, organization_id, expires_at, created_at, used_at, revoked_at
+        FROM public.refresh_token
+        WHERE user_id = $1 AND ` + tenant + `
+        ORDER BY created_at DESC
+    `
+	rows, err := rr.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.RefreshToken{}
+	for rows.Next() {
+		var token models.RefreshToken
+		var organizationID uuid.NullUUID
+		err := rows.Scan(
+			&token.ID,
+			&token.UserID,
+			&token.FamilyID,
+			&organizationID,
+			&token.ExpiresAt,
+			&token.CreatedAt,
+			&token.UsedAt,
+			&token.RevokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		token.TenantID = organizationID.UUID
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// MarkRefreshTokenUsed marks the token as rotated. It returns false if the token
+// had already been used or revoked, which means it is being replayed.
+func (rr *RefreshTokenRepository) MarkRefreshTokenUsed(id uuid.UUID) (bool, error) {
+	query := `
+        UPDATE public.refresh_token SET used_at = NOW()
+        WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL
+    `
+	result, err := rr.db.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// RevokeFamily revokes every refresh token descended from the same login
+func (rr *RefreshTokenRepository) RevokeFamily(familyID uuid.UUID) error {
+	query := `
+        UPDATE public.refresh_token SET revoked_at = NOW()
+        WHERE family_id = $1 AND revoked_at IS NULL
+    `
+	_, err := rr.db.Exec(query, familyID)
+	return err
+}
+
+// RevokeAllForUser revokes every outstanding refresh token of the user
+func (rr *RefreshTokenRepository) RevokeAllForUser(userID uuid.UUID) error {
+	query := `
+        UPDATE public.refresh_token SET revoked_at = NOW()
+        WHERE user_id = $1 AND revoked_at IS NULL
+    `
+	_, err := rr.db.Exec(query, userID)
+	return err
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+)
+
+// tenantScope restricts a repository's queries to one organization. The zero
+// value is unscoped. Repositories embed it and add its conditions to every
+// WHERE clause, so a repository built for a tenant cannot see other tenants'
+// rows.
+type tenantScope struct {
+	tenantID uuid.UUID
+}
+
+// userCondition limits the user IDs in column to members of the tenant,
+// appending the tenant to args
+func (t tenantScope) userCondition(column string, args []interface{}) (string, []interface{}) {
+	if t.tenantID == uuid.Nil {
+		return "TRUE", args
+	}
+	args = append(args, t.tenantID)
+	return fmt.Sprintf("%s IN (SELECT user_id FROM public.organization_member WHERE organization_id = $%d)", column, len(args)), args
+}
+
+// orgCondition limits rows whose organization is in column to the tenant,
+// appending the tenant to args. Tables that belong to one organization, such
+// as bookings, use it in place of userCondition.
+func (t tenantScope) orgCondition(column string, args []interface{}) (string, []interface{}) {
+	if t.tenantID == uuid.Nil {
+		return "TRUE", args
+	}
+	args = append(args, t.tenantID)
+	return fmt.Sprintf("%s = $%d", column, len(args)), args
+}
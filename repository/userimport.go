@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"booking-service/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"log"
+)
+
+// ImportResult counts the users an import wrote
+type ImportResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	// PasswordChanged lists the existing users the import gave a new password
+	PasswordChanged []uuid.UUID `json:"-"`
+}
+
+// ExistingUserRoles returns the roles of the live users holding the lower-cased usernames
+func (ur *UserRepository) ExistingUserRoles(usernames []string) (map[string]string, error) {
+	tenant, args := ur.userCondition("id", []interface{}{pq.Array(usernames)})
+	query := `
+        SELECT lower(username), role FROM public."user"
+        WHERE lower(username) = ANY($1) AND deleted_at IS NULL AND ` + tenant + `
+    `
+	rows, err := ur.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := map[string]string{}
+	for rows.Next() {
+		var username, role string
+		if err := rows.Scan(&username, &role); err != nil {
+			return nil, err
+		}
+		existing[username] = role
+	}
+	return existing, rows.Err()
+}
+
+// ImportUsers upserts users by case-insensitive username in one transaction.
+// A tenant-scoped repository only updates members of its tenant and adds the
+// users it creates to it.
+// Rows are bulk-loaded with COPY into a temporary table; existing users get
+// the row's names and role, plus its password when Password is set, and the
+// remaining rows are inserted. Passwords must already be hashed, and every
+// row that creates a user must have one.
+func (ur *UserRepository) ImportUsers(users []models.User) (ImportResult, error) {
+	var result ImportResult
+
+	tx, err := ur.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        CREATE TEMPORARY TABLE user_import (
+            id          uuid,
+            first_name  text,
+            last_name   text,
+            password    text,
+            role        text,
+            username    text
+        ) ON COMMIT DROP
+    `
+	if _, err := tx.Exec(query); err != nil {
+		return result, err
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("user_import", "id", "first_name", "last_name", "password", "role", "username"))
+	if err != nil {
+		return result, err
+	}
+	for _, user := range users {
+		var passwordHash interface{}
+		if user.Password != "" {
+			passwordHash = user.Password
+		}
+		if _, err := stmt.Exec(uuid.New(), user.FirstName, user.LastName, passwordHash, user.Role, user.Username); err != nil {
+			stmt.Close()
+			return result, err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return result, err
+	}
+	if err := stmt.Close(); err != nil {
+		return result, err
+	}
+
+	tenant, args := ur.userCondition("u.id", nil)
+	query = `
+        UPDATE public."user" u SET
+            first_name = i.first_name,
+            last_name = i.last_name,
+            role = i.role,
+            password = coalesce(i.password, u.password),
+            updated_at = NOW(),
+            version = u.version + 1
+        FROM user_import i
+        WHERE lower(u.username) = lower(i.username) AND u.deleted_at IS NULL AND ` + tenant + `
+        RETURNING u.id, i.password IS NOT NULL
+    `
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		var passwordChanged bool
+		if err := rows.Scan(&id, &passwordChanged); err != nil {
+			rows.Close()
+			return result, err
+		}
+		result.Updated++
+		if passwordChanged {
+			result.PasswordChanged = append(result.PasswordChanged, id)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return result, err
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	query = `
+        INSERT INTO public."user" (id, first_name, last_name, password, role, username, created_at, updated_at)
+        SELECT i.id, i.first_name, i.last_name, i.password, i.role, lower(i.username), NOW(), NOW()
+        FROM user_import i
+        WHERE NOT EXISTS (
+            SELECT 1 FROM public."user" u WHERE lower(u.username) = lower(i.username) AND u.deleted_at IS NULL
+        )
+    `
+	inserted, err := tx.Exec(query)
+	if isUniqueViolation(err, usernameUniqueIndex) {
+		return result, ErrDuplicateUsername
+	}
+	if err != nil {
+		return result, err
+	}
+	n, err := inserted.RowsAffected()
+	if err != nil {
+		return result, err
+	}
+	result.Created = int(n)
+
+	// A scoped import skips usernames held by users outside the tenant in
+	// both statements above; those names are taken
+	if result.Created+result.Updated < len(users) {
+		return result, ErrDuplicateUsername
+	}
+
+	// Users created within a tenant become members of it
+	if ur.tenantID != uuid.Nil {
+		query = `
+            INSERT INTO public.organization_member (organization_id, user_id, role, created_at)
+            SELECT $1, i.id, $2, NOW()
+            FROM user_import i
+            JOIN public."user" u ON u.id = i.id
+        `
+		if _, err := tx.Exec(query, ur.tenantID, models.OrgRoleMember); err != nil {
+			return result, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+
+	log.Printf("Imported users: %d created, %d updated", result.Created, result.Updated)
+	return result, nil
+}
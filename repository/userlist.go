@@ -0,0 +1,266 @@
+package repository
+
+import (
+	"booking-service/models"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+// Values of UserListOptions.Deleted
+const (
+	DeletedExclude = "exclude"
+	DeletedInclude = "include"
+	DeletedOnly    = "only"
+)
+
+// userSortColumns whitelists the fields users can be sorted by and whether
+// their cursor values are timestamps
+var userSortColumns = map[string]struct {
+	expr string
+	time bool
+}{
+	"created_at": {expr: "created_at", time: true},
+	"updated_at": {expr: "updated_at", time: true},
+	"username":   {expr: "lower(username)"},
+	"first_name": {expr: "first_name"},
+	"last_name":  {expr: "last_name"},
+}
+
+var (
+	ErrInvalidSort   = errors.New("repository: invalid sort field")
+	ErrInvalidCursor = errors.New("repository: invalid cursor")
+)
+
+// UserCursor is a position in a sorted user list: the sort value and ID of a row
+type UserCursor struct {
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+type UserListOptions struct {
+	Role           string
+	UsernamePrefix string
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	Deleted        string
+
+	SortField string
+	SortDesc  bool
+	Limit     int
+
+	// Cursor, when set, starts the page just after it, or just before it when Backward is set
+	Cursor   *UserCursor
+	Backward bool
+}
+
+// UserPage describes the page StreamUsers produced
+type UserPage struct {
+	// First and Last are the cursors of the first and last rows streamed
+	First *UserCursor
+	Last  *UserCursor
+	// HasMore reports whether more rows exist in the paging direction
+	HasMore bool
+}
+
+// IsSortField reports whether users can be sorted by field
+func IsSortField(field string) bool {
+	_, ok := userSortColumns[field]
+	return ok
+}
+
+// CountUsers returns how many users match the filters, ignoring the cursor
+func (ur *UserRepository) CountUsers(opts UserListOptions) (int, error) {
+	where, args := ur.userListFilters(opts)
+	query := `SELECT count(*) FROM public."user" WHERE ` + strings.Join(where, " AND ")
+
+	var total int
+	if err := ur.db.QueryRow(query, args...).Scan(&total); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// StreamUsers runs a keyset-paginated query and calls fn for each user in
+// display order as rows arrive, so the page is never held in memory
+func (ur *UserRepository) StreamUsers(opts UserListOptions, fn func(models.User) error) (UserPage, error) {
+	sort, ok := userSortColumns[opts.SortField]
+	if !ok {
+		return UserPage{}, ErrInvalidSort
+	}
+
+	where, args := ur.userListFilters(opts)
+
+	// Paging backward walks the index in reverse and the outer query restores display order
+	desc := opts.SortDesc != opts.Backward
+	if opts.Cursor != nil {
+		var value interface{} = opts.Cursor.Value
+		if sort.time {
+			t, err := time.Parse(time.RFC3339Nano, opts.Cursor.Value)
+			if err != nil {
+				return UserPage{}, ErrInvalidCursor
+			}
+			value = t
+		}
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		args = append(args, value, opts.Cursor.ID)
+		where = append(where, fmt.Sprintf("(%s, id) %s ($%d, $%d)", sort.expr, op, len(args)-1, len(args)))
+	}
+
+	order := func(expr string, desc bool) string {
+		if desc {
+			return expr + " DESC, id DESC"
+		}
+		return expr + " ASC, id ASC"
+	}
+
+	// One extra row tells us whether there is another page
+	args = append(args, opts.Limit+1)
+	query := fmt.Sprintf(`
+        SELECT id, first_name, last_name, role, username, created_at, updated_at, deleted_at, email_verified_at, version, sort_value, rn
+        FROM (
+            SELECT id, first_name, last_name, role, lower(username) AS username, created_at, updated_at, deleted_at, email_verified_at, version,
+                %[1]s AS sort_value,
+                row_number() OVER (ORDER BY %[2]s) AS rn
+            FROM public."user"
+            WHERE %[3]s
+            ORDER BY %[2]s
+            LIMIT $%[4]d
+        ) page
+        ORDER BY %[5]s
+    `, sort.expr, order(sort.expr, desc), strings.Join(where, " AND "), len(args), order("sort_value", opts.SortDesc))
+
+	rows, err := ur.db.Query(query, args...)
+	if err != nil {
+		return UserPage{}, err
+	}
+	defer rows.Close()
+
+	var page UserPage
+	for rows.Next() {
+		var user models.User
+		var sortValue interface{}
+		var rn int
+		err := rows.Scan(
+			&user.ID,
+			&user.FirstName,
+			&user.LastName,
+			&user.Role,
+			&user.Username,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.DeletedAt,
+			&user.EmailVerifiedAt,
+			&user.Version,
+			&sortValue,
+			&rn,
+		)
+		if err != nil {
+			return page, err
+		}
+		if rn > opts.Limit {
+			page.HasMore = true
+			continue
+		}
+
+		cursor := &UserCursor{ID: user.ID}
+		switch v := sortValue.(type) {
+		case time.Time:
+			cursor.Value = v.Format(time.RFC3339Nano)
+		case []byte:
+			cursor.Value = string(v)
+		case string:
+			cursor.Value = v
+		}
+		if page.First == nil {
+			page.First = cursor
+		}
+		page.Last = cursor
+
+		if err := fn(user); err != nil {
+			return page, err
+		}
+	}
+
+	return page, rows.Err()
+}
+
+// ExportUsers calls fn for every user matching the filters, oldest first, as
+// rows arrive. The sort and cursor options are ignored.
+func (ur *UserRepository) ExportUsers(opts UserListOptions, fn func(models.User) error) error {
+	where, args := ur.userListFilters(opts)
+	query := `
+        SELECT id, first_name, last_name, role, lower(username), created_at, updated_at, deleted_at, email_verified_at, version
+        FROM public."user"
+        WHERE ` + strings.Join(where, " AND ") + `
+        ORDER BY created_at, id
+    `
+
+	rows, err := ur.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(
+			&user.ID,
+			&user.FirstName,
+			&user.LastName,
+			&user.Role,
+			&user.Username,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.DeletedAt,
+			&user.EmailVerifiedAt,
+			&user.Version,
+		)
+		if err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// userListFilters builds the WHERE conditions shared by CountUsers, StreamUsers and ExportUsers
+func (ur *UserRepository) userListFilters(opts UserListOptions) ([]string, []interface{}) {
+	tenant, args := ur.userCondition("id", nil)
+	where := []string{tenant}
+
+	switch opts.Deleted {
+	case DeletedInclude:
+	case DeletedOnly:
+		where = append(where, "deleted_at IS NOT NULL")
+	default:
+		where = append(where, "deleted_at IS NULL")
+	}
+	if opts.Role != "" {
+		args = append(args, opts.Role)
+		where = append(where, fmt.Sprintf("role = $%d", len(args)))
+	}
+	if opts.UsernamePrefix != "" {
+		// Escape LIKE wildcards so the prefix is matched literally
+		prefix := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(opts.UsernamePrefix))
+		args = append(args, prefix+"%")
+		where = append(where, fmt.Sprintf("lower(username) LIKE $%d", len(args)))
+	}
+	if opts.CreatedAfter != nil {
+		args = append(args, *opts.CreatedAfter)
+		where = append(where, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if opts.CreatedBefore != nil {
+		args = append(args, *opts.CreatedBefore)
+		where = append(where, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	return where, args
+}
@@ -1,119 +1,446 @@
 package repository
 
 import (
-    "database/sql"
+	"booking-service/models"
+	"booking-service/password"
+	"database/sql"
+	"fmt"
 	"github.com/google/uuid"
-    "booking-service/models"
+	"github.com/lib/pq"
 	"log"
-	"time"
 	"strings" // Adjust the import path based on your project structure
+	"time"
 )
 
+// usernameUniqueIndex enforces case-insensitive username uniqueness among live users
+const usernameUniqueIndex = "user_username_lower_key"
+
 type UserRepository struct {
-    db *sql.DB // or *sql.Tx if you want to support transactions
+	db *sql.DB // or *sql.Tx if you want to support transactions
+	tenantScope
 }
 
 func NewUserRepository(db *sql.DB) *UserRepository {
-    return &UserRepository{db: db}
+	return &UserRepository{db: db}
+}
+
+// ForTenant returns a repository that only sees members of the organization,
+// and adds the users it creates to it. uuid.Nil leaves the repository unscoped.
+func (ur *UserRepository) ForTenant(tenantID uuid.UUID) *UserRepository {
+	return &UserRepository{db: ur.db, tenantScope: tenantScope{tenantID: tenantID}}
+}
+
+// otherMembership is true for users, aliased u, who are members of an
+// organization other than the one in $2
+const otherMembership = `EXISTS (
+            SELECT 1 FROM public.organization_member m
+            WHERE m.user_id = u.id AND m.organization_id <> $2
+        )`
+
+// IsSharedUser reports whether the user is also a member of an organization
+// other than the repository's. An unscoped repository shares no one.
+func (ur *UserRepository) IsSharedUser(userID uuid.UUID) (bool, error) {
+	if ur.tenantID == uuid.Nil {
+		return false, nil
+	}
+	var shared bool
+	query := `SELECT ` + otherMembership + ` FROM public."user" u WHERE u.id = $1`
+	err := ur.db.QueryRow(query, userID, ur.tenantID).Scan(&shared)
+	if err == sql.ErrNoRows {
+		return false, errUserNotFound
+	}
+	return shared, err
+}
+
+// SharedUsernames returns which of the lower-case usernames belong to live
+// users who are also members of an organization other than the repository's.
+// An unscoped repository shares no one.
+func (ur *UserRepository) SharedUsernames(usernames []string) (map[string]bool, error) {
+	shared := map[string]bool{}
+	if ur.tenantID == uuid.Nil {
+		return shared, nil
+	}
+	query := `
+        SELECT lower(u.username) FROM public."user" u
+        WHERE lower(u.username) = ANY($1) AND u.deleted_at IS NULL AND ` + otherMembership + `
+    `
+	rows, err := ur.db.Query(query, pq.Array(usernames), ur.tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		shared[username] = true
+	}
+	return shared, rows.Err()
+}
+
+// validateUser checks the fields every stored user must have
+func validateUser(user models.User, isNew bool) error {
+	fields := map[string]string{}
+	if strings.TrimSpace(user.Username) == "" {
+		fields["username"] = "is required"
+	} else if len(user.Username) > 255 {
+		fields["username"] = "must be at most 255 characters"
+	}
+	if user.Role == "" {
+		fields["role"] = "is required"
+	} else if !models.IsRole(user.Role) {
+		fields["role"] = "is not a known role"
+	}
+	if isNew && user.Password == "" {
+		fields["password"] = "is required"
+	}
+	if len(fields) > 0 {
+		return &Error{Kind: ErrValidation, Message: "invalid user", Fields: fields}
+	}
+	return nil
 }
 
 func (ur *UserRepository) InsertUser(user models.User) (models.User, error) {
-    // Generate a new UUID for the user
-    userID := uuid.New()
+	if err := validateUser(user, true); err != nil {
+		return models.User{}, err
+	}
+
+	// Generate a new UUID for the user
+	userID := uuid.New()
+
+	// Never store the plaintext password
+	hashedPassword := password.Unusable
+	var err error
+	if user.Password != password.Unusable {
+		if hashedPassword, err = password.Hash(user.Password); err != nil {
+			return models.User{}, err
+		}
+	}
 
-    // Define the SQL query for inserting a user with a manually generated UUID
-    query := `
+	// Define the SQL query for inserting a user with a manually generated UUID
+	query := `
         INSERT INTO "user" (id, first_name, last_name, password, role, username, created_at, updated_at)
         VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
     `
-    // Execute the SQL query within the repository's database connection
-    _, err := ur.db.Exec(query, userID, user.FirstName, user.LastName, user.Password, user.Role, user.Username)
-    if err != nil {
-        return models.User{}, err
-    }
+	args := []interface{}{userID, user.FirstName, user.LastName, hashedPassword, user.Role, user.Username}
+
+	// Users created within a tenant become members of it in the same statement
+	if ur.tenantID != uuid.Nil {
+		query = `
+        WITH inserted AS (
+            INSERT INTO "user" (id, first_name, last_name, password, role, username, created_at, updated_at)
+            VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
+            RETURNING id
+        )
+        INSERT INTO public.organization_member (organization_id, user_id, role, created_at)
+        SELECT $7, id, $8, NOW() FROM inserted
+        `
+		args = append(args, ur.tenantID, models.OrgRoleMember)
+	}
+
+	// Execute the SQL query within the repository's database connection
+	_, err = ur.db.Exec(query, args...)
+	if isUniqueViolation(err, usernameUniqueIndex) {
+		return models.User{}, ErrDuplicateUsername
+	}
+	if err != nil {
+		return models.User{}, err
+	}
 
 	log.Printf("Inserted user with ID: %s", userID)
 
-    // Set the generated user ID to the user struct
-    user.ID = userID
+	// Set the generated user ID to the user struct
+	user.ID = userID
+	user.Password = hashedPassword
+	user.Version = 1
+
+	return user, nil
+}
 
-    return user, nil
+// UpdatePassword stores a new password hash for the user
+func (ur *UserRepository) UpdatePassword(userID uuid.UUID, passwordHash string) error {
+	tenant, args := ur.userCondition("id", []interface{}{passwordHash, userID})
+	query := `
+	UPDATE public."user" SET password = $1, updated_at = NOW(), version = version + 1 WHERE id = $2 AND ` + tenant + `
+    `
+	_, err := ur.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	return nil
 }
 
+// UpdateUser overwrites the user's profile fields. When user.Version is set the
+// update only applies if the stored version still matches; otherwise
+// ErrPreconditionFailed is returned.
 func (ur *UserRepository) UpdateUser(user models.User, userID uuid.UUID) (models.User, error) {
-    // Generate a new UUID for the user
+	if err := validateUser(user, false); err != nil {
+		return models.User{}, err
+	}
+
 	currentTime := time.Now()
-    formattedTime := currentTime.Format("2006-01-02T15:04:05.999999Z")
-    // Define the SQL query for inserting a user with a manually generated UUID
-    query := `
-	UPDATE public."user" SET first_name = $1, last_name = $2, role = $3, username =$4, updated_at=$6  WHERE id = $5
+	formattedTime := currentTime.Format("2006-01-02T15:04:05.999999Z")
+	username := strings.ToLower(user.Username)
+
+	tx, err := ur.db.Begin()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	// A new address has not been verified by anyone
+	tenant, args := ur.userCondition("id", []interface{}{user.FirstName, user.LastName, user.Role, username, userID, formattedTime, user.Version})
+	query := `
+	UPDATE public."user" SET first_name = $1, last_name = $2, role = $3, username =$4, updated_at=$6, version = version + 1,
+	    email_verified_at = CASE WHEN lower(username) = $4 THEN email_verified_at END
+	WHERE id = $5 AND deleted_at IS NULL AND ($7 = 0 OR version = $7) AND ` + tenant + `
+	RETURNING version, email_verified_at
     `
-    _, err := ur.db.Exec(query, user.FirstName, user.LastName, user.Role, strings.ToLower(user.Username), userID, formattedTime)
-    if err != nil {
-        return models.User{}, err
-    }
+	err = tx.QueryRow(query, args...).Scan(&user.Version, &user.EmailVerifiedAt)
+	if isUniqueViolation(err, usernameUniqueIndex) {
+		return models.User{}, ErrDuplicateUsername
+	}
+	if err == sql.ErrNoRows {
+		return models.User{}, ur.casFailure(userID)
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := discardStaleUserTokens(tx, userID, username); err != nil {
+		return models.User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.User{}, err
+	}
 
 	log.Printf("updated user by ID: %s", userID)
 
-    // Set the generated user ID to the user struct
-    user.ID = userID
-	user.Username = strings.ToLower(user.Username)
+	// Set the generated user ID to the user struct
+	user.ID = userID
+	user.Username = username
 	user.UpdatedAt, err = time.Parse("2006-01-02T15:04:05.999999Z", formattedTime)
-	log.Printf("time ->> %s",formattedTime)
-    return user, nil
+	return user, nil
+}
+
+// casFailure explains why a versioned write matched no rows: the user is gone
+// or was changed by someone else
+func (ur *UserRepository) casFailure(userID uuid.UUID) error {
+	var exists bool
+	tenant, args := ur.userCondition("id", []interface{}{userID})
+	query := `SELECT EXISTS (SELECT 1 FROM public."user" WHERE id = $1 AND deleted_at IS NULL AND ` + tenant + `)`
+	if err := ur.db.QueryRow(query, args...).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return errVersionMismatch
+	}
+	return errUserNotFound
+}
+
+// PatchUser updates only the columns set in the patch and returns the stored
+// user. A non-zero version makes the update conditional as in UpdateUser.
+func (ur *UserRepository) PatchUser(userID uuid.UUID, patch models.UserPatch, version int) (models.User, error) {
+	if patch.IsEmpty() {
+		user, err := ur.GetUserByID(userID)
+		if err == nil && version != 0 && user.Version != version {
+			return models.User{}, errVersionMismatch
+		}
+		return user, err
+	}
+
+	fields := map[string]string{}
+	if patch.Username != nil && strings.TrimSpace(*patch.Username) == "" {
+		fields["username"] = "is required"
+	} else if patch.Username != nil && len(*patch.Username) > 255 {
+		fields["username"] = "must be at most 255 characters"
+	}
+	if patch.Role != nil && *patch.Role == "" {
+		fields["role"] = "is required"
+	} else if patch.Role != nil && !models.IsRole(*patch.Role) {
+		fields["role"] = "is not a known role"
+	}
+	if len(fields) > 0 {
+		return models.User{}, &Error{Kind: ErrValidation, Message: "invalid user", Fields: fields}
+	}
+
+	args := []interface{}{userID, version}
+	var set []string
+	add := func(column string, value interface{}) {
+		args = append(args, value)
+		set = append(set, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if patch.FirstName != nil {
+		add("first_name", *patch.FirstName)
+	}
+	if patch.LastName != nil {
+		add("last_name", *patch.LastName)
+	}
+	if patch.Role != nil {
+		add("role", *patch.Role)
+	}
+	if patch.Username != nil {
+		add("username", strings.ToLower(*patch.Username))
+		// A new address has not been verified by anyone
+		set = append(set, fmt.Sprintf("email_verified_at = CASE WHEN lower(username) = $%d THEN email_verified_at END", len(args)))
+	}
+
+	tx, err := ur.db.Begin()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	tenant, args := ur.userCondition("id", args)
+	query := `
+	UPDATE public."user" SET ` + strings.Join(set, ", ") + `, updated_at = NOW(), version = version + 1
+	WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2) AND ` + tenant + `
+	RETURNING id, first_name, last_name, role, lower(username), created_at, updated_at, deleted_at, email_verified_at, version
+    `
+
+	var user models.User
+	err = tx.QueryRow(query, args...).Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Role,
+		&user.Username,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.DeletedAt,
+		&user.EmailVerifiedAt,
+		&user.Version,
+	)
+	if isUniqueViolation(err, usernameUniqueIndex) {
+		return models.User{}, ErrDuplicateUsername
+	}
+	if err == sql.ErrNoRows {
+		return models.User{}, ur.casFailure(userID)
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	if patch.Username != nil {
+		if err := discardStaleUserTokens(tx, userID, user.Username); err != nil {
+			return models.User{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return models.User{}, err
+	}
+
+	log.Printf("patched user by ID: %s", userID)
+	return user, nil
+}
+
+// MarkEmailVerified records that the user proved ownership of the mailbox at
+// email, provided it is still their username
+func (ur *UserRepository) MarkEmailVerified(userID uuid.UUID, email string) error {
+	tenant, args := ur.userCondition("id", []interface{}{userID, strings.ToLower(email)})
+	query := `
+	UPDATE public."user" SET email_verified_at = NOW(), version = version + 1
+	WHERE id = $1 AND lower(username) = $2 AND email_verified_at IS NULL AND ` + tenant + `
+    `
+	_, err := ur.db.Exec(query, args...)
+	return err
 }
 
 func (ur *UserRepository) GetUserByID(userID uuid.UUID) (models.User, error) {
-    // Define the SQL query for retrieving a user by ID
-    query := `
-        SELECT id, first_name, last_name, role, lower(username), created_at, updated_at, deleted_at
+	// Define the SQL query for retrieving a user by ID
+	tenant, args := ur.userCondition("id", []interface{}{userID})
+	query := `
+        SELECT id, first_name, last_name, role, lower(username), created_at, updated_at, deleted_at, email_verified_at, version
         FROM public."user"
-        WHERE id = $1 and deleted_at is null
+        WHERE id = $1 and deleted_at is null AND ` + tenant + `
     `
 
-    var user models.User
-    err := ur.db.QueryRow(query, userID).Scan(
-        &user.ID,
-        &user.FirstName,
-        &user.LastName,
-        &user.Role,
-        &user.Username,
+	var user models.User
+	err := ur.db.QueryRow(query, args...).Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Role,
+		&user.Username,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.DeletedAt,
-    )
-    if err != nil {
-        return models.User{}, err
-    }
+		&user.EmailVerifiedAt,
+		&user.Version,
+	)
+	if err == sql.ErrNoRows {
+		return models.User{}, errUserNotFound
+	}
+	if err != nil {
+		return models.User{}, err
+	}
 
-    return user, nil
+	return user, nil
+}
+
+// GetUserByIDIncludingDeleted returns the user even if it has been soft-deleted
+func (ur *UserRepository) GetUserByIDIncludingDeleted(userID uuid.UUID) (models.User, error) {
+	tenant, args := ur.userCondition("id", []interface{}{userID})
+	query := `
+        SELECT id, first_name, last_name, role, lower(username), created_at, updated_at, deleted_at, email_verified_at, version
+        FROM public."user"
+        WHERE id = $1 AND ` + tenant + `
+    `
+
+	var user models.User
+	err := ur.db.QueryRow(query, args...).Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Role,
+		&user.Username,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.DeletedAt,
+		&user.EmailVerifiedAt,
+		&user.Version,
+	)
+	if err == sql.ErrNoRows {
+		return models.User{}, errUserNotFound
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
 }
 
 func (ur *UserRepository) GetUserByEmail(email string) (models.User, error) {
-    // Define the SQL query for retrieving a user by ID
-    query := `
-        SELECT id, first_name, last_name, role, lower(username), password, created_at, updated_at, deleted_at
+	// Define the SQL query for retrieving a user by ID
+	tenant, args := ur.userCondition("id", []interface{}{strings.ToLower(email)})
+	query := `
+        SELECT id, first_name, last_name, role, lower(username), password, created_at, updated_at, deleted_at, email_verified_at, version
         FROM public."user"
-        WHERE lower(username) = $1 and deleted_at is null
+        WHERE lower(username) = $1 and deleted_at is null AND ` + tenant + `
     `
 
-    var user models.User
-    err := ur.db.QueryRow(query, strings.ToLower(email)).Scan(
-        &user.ID,
-        &user.FirstName,
-        &user.LastName,
-        &user.Role,
-        &user.Username,
+	var user models.User
+	err := ur.db.QueryRow(query, args...).Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Role,
+		&user.Username,
 		&user.Password,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.DeletedAt,
-    )
-    if err != nil {
-        return models.User{}, err
-    }
+		&user.EmailVerifiedAt,
+		&user.Version,
+	)
+	if err == sql.ErrNoRows {
+		return models.User{}, errUserNotFound
+	}
+	if err != nil {
+		return models.User{}, err
+	}
 
-    return user, nil
+	return user, nil
 }
 
 // func (ur *UserRepository) HardDeleteUserById(id uuid.UUID) error {
@@ -131,54 +458,103 @@ func (ur *UserRepository) GetUserByEmail(email string) (models.User, error) {
 // 	return nil
 // }
 
-func (ur *UserRepository) SoftDeleteUserById(id uuid.UUID) error {
-    // Define the SQL query for retrieving a user by ID
-    query := `
-	UPDATE public."user" SET deleted_at= Now() WHERE id = $1
+// SoftDeleteUserById marks the user deleted. A non-zero version makes the
+// delete conditional as in UpdateUser.
+func (ur *UserRepository) SoftDeleteUserById(id uuid.UUID, version int) error {
+	tenant, args := ur.userCondition("id", []interface{}{id, version})
+	query := `
+	UPDATE public."user" SET deleted_at= Now(), version = version + 1
+	WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2) AND ` + tenant + `
     `
 
-	_, err := ur.db.Exec(query, id)
-    if err != nil {
-        return err
-    }
+	result, err := ur.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ur.casFailure(id)
+	}
 	return nil
 }
 
+// RestoreUser undoes a soft delete. Erased users cannot be restored. It returns ErrDuplicateUsername when the
+// username has since been taken by another user.
+func (ur *UserRepository) RestoreUser(id uuid.UUID) (models.User, error) {
+	tenant, args := ur.userCondition("id", []interface{}{id})
+	query := `
+	UPDATE public."user" SET deleted_at = NULL, updated_at = NOW(), version = version + 1
+	WHERE id = $1 AND deleted_at IS NOT NULL AND erased_at IS NULL AND ` + tenant + `
+	RETURNING id, first_name, last_name, role, lower(username), created_at, updated_at, deleted_at, email_verified_at, version
+    `
+
+	var user models.User
+	err := ur.db.QueryRow(query, args...).Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Role,
+		&user.Username,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.DeletedAt,
+		&user.EmailVerifiedAt,
+		&user.Version,
+	)
+	if isUniqueViolation(err, usernameUniqueIndex) {
+		return models.User{}, ErrDuplicateUsername
+	}
+	if err == sql.ErrNoRows {
+		return models.User{}, errDeletedUserNotFound
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	log.Printf("restored user by ID: %s", id)
+	return user, nil
+}
+
 func (ur *UserRepository) GetAllUsers() ([]models.User, error) {
-    // Define the SQL query for retrieving all users
-    query := `
-        SELECT id, first_name, last_name, role, lower(username), created_at, updated_at, deleted_at
+	// Define the SQL query for retrieving all users
+	tenant, args := ur.userCondition("id", nil)
+	query := `
+        SELECT id, first_name, last_name, role, lower(username), created_at, updated_at, deleted_at, email_verified_at, version
         FROM public."user"
+        WHERE ` + tenant + `
     `
 
-    // Execute the SQL query within the repository's database connection
-    rows, err := ur.db.Query(query)
-    if err != nil {
-        return nil, err
-    }
-    defer rows.Close()
-
-    // Initialize a slice to store the retrieved users
-    var users []models.User
-
-    // Iterate through the query results and append users to the slice
-    for rows.Next() {
-        var user models.User
-        err := rows.Scan(
-            &user.ID,
-            &user.FirstName,
-            &user.LastName,
-            &user.Role,
-            &user.Username,
+	// Execute the SQL query within the repository's database connection
+	rows, err := ur.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Initialize a slice to store the retrieved users
+	var users []models.User
+
+	// Iterate through the query results and append users to the slice
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(
+			&user.ID,
+			&user.FirstName,
+			&user.LastName,
+			&user.Role,
+			&user.Username,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 			&user.DeletedAt,
-        )
-        if err != nil {
-            return nil, err
-        }
-        users = append(users, user)
-    }
-
-    return users, nil
-}
\ No newline at end of file
+			&user.EmailVerifiedAt,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"log"
+	"time"
+)
+
+// purgeBatchSize bounds how many users one PurgeDeletedUsers transaction removes
+const purgeBatchSize = 100
+
+// userCredentialStatements delete the sessions, credentials, linked accounts,
+// grants, organization and group memberships of the users in $1, and the
+// invitations they accepted or that are still addressed to them. Invitations
+// to an address a live user has since taken are theirs and are kept. Both
+// erasure and purge run them.
+var userCredentialStatements = []string{
+	`DELETE FROM public.refresh_token WHERE user_id = ANY($1)`,
+	`DELETE FROM public.mfa_recovery_code WHERE user_id = ANY($1)`,
+	`DELETE FROM public.user_mfa WHERE user_id = ANY($1)`,
+	`DELETE FROM public.user_token WHERE user_id = ANY($1)`,
+	`DELETE FROM public.api_key WHERE user_id = ANY($1)`,
+	`DELETE FROM public.user_identity WHERE user_id = ANY($1)`,
+	`DELETE FROM public.oauth_authorization_code WHERE user_id = ANY($1)`,
+	`DELETE FROM public.oauth_consent WHERE user_id = ANY($1)`,
+	`DELETE FROM public.organization_member WHERE user_id = ANY($1)`,
+	`DELETE FROM public.user_group_member WHERE user_id = ANY($1)`,
+	`DELETE FROM public.invitation WHERE user_id = ANY($1)`,
+	`DELETE FROM public.invitation i
+        WHERE i.user_id IS NULL
+          AND lower(i.email) IN (SELECT lower(username) FROM public."user" WHERE id = ANY($1))
+          AND NOT EXISTS (
+              SELECT 1 FROM public."user" o
+              WHERE lower(o.username) = lower(i.email) AND o.deleted_at IS NULL AND NOT o.id = ANY($1)
+          )`,
+	`DELETE FROM public.login_attempt WHERE kind = 'account' AND key IN (SELECT lower(username) FROM public."user" WHERE id = ANY($1))`,
+}
+
+// userPurgeStatements remove or anonymize everything else that references the
+// users in $1 and then delete the users. Audit records are kept with the user
+// columns cleared.
+var userPurgeStatements = append(append([]string{}, userCredentialStatements...),
+	`DELETE FROM public.revoked_token WHERE user_id = ANY($1)`,
+	`DELETE FROM public.user_token_cutoff WHERE user_id = ANY($1)`,
+	`UPDATE public.oauth_client SET created_by = NULL WHERE created_by = ANY($1)`,
+	`UPDATE public.invitation SET invited_by = NULL WHERE invited_by = ANY($1)`,
+	`UPDATE public.impersonation_session SET actor_id = NULL WHERE actor_id = ANY($1)`,
+	`UPDATE public.impersonation_session SET subject_id = NULL WHERE subject_id = ANY($1)`,
+	`UPDATE public.impersonation_audit SET actor_id = NULL WHERE actor_id = ANY($1)`,
+	`UPDATE public.impersonation_audit SET subject_id = NULL WHERE subject_id = ANY($1)`,
+	`DELETE FROM public."user" WHERE id = ANY($1)`,
+)
+
+// PurgeDeletedUsers permanently deletes users soft-deleted before cutoff,
+// along with their dependent records, and returns how many were removed.
+// Users are purged in batches, each in its own transaction.
+func (ur *UserRepository) PurgeDeletedUsers(cutoff time.Time) (int, error) {
+	purged := 0
+	for {
+		n, err := ur.purgeBatch(cutoff)
+		purged += n
+		if err != nil || n < purgeBatchSize {
+			return purged, err
+		}
+	}
+}
+
+func (ur *UserRepository) purgeBatch(cutoff time.Time) (int, error) {
+	tx, err := ur.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the batch so a concurrent restore cannot bring a user back mid-purge
+	query := `
+        SELECT id FROM public."user"
+        WHERE deleted_at IS NOT NULL AND deleted_at < $1
+        ORDER BY deleted_at
+        LIMIT $2
+        FOR UPDATE SKIP LOCKED
+    `
+	rows, err := tx.Query(query, cutoff, purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	for _, statement := range userPurgeStatements {
+		if _, err := tx.Exec(statement, pq.Array(ids)); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("Purged %d users deleted before %s", len(ids), cutoff.Format(time.RFC3339))
+	return len(ids), nil
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"booking-service/models"
+	"strings"
+	"unicode"
+)
+
+// userSearchText is the text trigram matching runs against. It must match the
+// expression of user_search_trgm_idx for the index to be used.
+const userSearchText = `lower(coalesce(first_name, '') || ' ' || coalesce(last_name, '') || ' ' || coalesce(username, ''))`
+
+// userSearchSimilarity is the lowest word similarity a fuzzy match needs. It
+// is below pg_trgm's default of 0.6 so single typos in short names still match.
+const userSearchSimilarity = "0.3"
+
+type UserSearchOptions struct {
+	Query  string
+	Limit  int
+	Offset int
+}
+
+// UserSearchResult is a user matching a search and how well it matched
+type UserSearchResult struct {
+	User models.User
+	Rank float64
+}
+
+// SearchTerms splits a search query into the lowercase words it matches on.
+// Email punctuation is kept so addresses stay whole words.
+func SearchTerms(q string) []string {
+	var terms []string
+	for _, term := range strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '@' && r != '.' && r != '_'
+	}) {
+		term = strings.Trim(term, "@._")
+		if term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// userSearchTSQuery builds a tsquery matching every term as a word prefix
+func userSearchTSQuery(terms []string) string {
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		prefixes[i] = term + ":*"
+	}
+	return strings.Join(prefixes, " & ")
+}
+
+// SearchUsers finds live users whose names or username contain the query's
+// words, as prefixes, or resemble it closely enough to catch typos. Results
+// are ordered best match first; Rank adds the full-text rank and the trigram
+// word similarity.
+func (ur *UserRepository) SearchUsers(opts UserSearchOptions) ([]UserSearchResult, error) {
+	terms := SearchTerms(opts.Query)
+	if len(terms) == 0 {
+		return []UserSearchResult{}, nil
+	}
+
+	tx, err := ur.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The <% operator uses the threshold, which keeps the trigram index usable
+	if _, err := tx.Exec(`SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`, userSearchSimilarity); err != nil {
+		return nil, err
+	}
+
+	tenant, args := ur.userCondition("id", []interface{}{strings.Join(terms, " "), userSearchTSQuery(terms), opts.Limit, opts.Offset})
+	query := `
+        SELECT id, first_name, last_name, role, lower(username), created_at, updated_at, deleted_at, email_verified_at, version, rank
+        FROM (
+            SELECT *, ts_rank(search_vector, to_tsquery('simple', $2)) + word_similarity($1, ` + userSearchText + `) AS rank
+            FROM public."user"
+            WHERE deleted_at IS NULL AND ` + tenant + `
+              AND (search_vector @@ to_tsquery('simple', $2) OR $1 <% ` + userSearchText + `)
+        ) matches
+        ORDER BY rank DESC, id
+        LIMIT $3 OFFSET $4
+    `
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []UserSearchResult{}
+	for rows.Next() {
+		var result UserSearchResult
+		user := &result.User
+		if err := rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Role, &user.Username, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.EmailVerifiedAt, &user.Version, &result.Rank); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, tx.Commit()
+}
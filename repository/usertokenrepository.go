@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"booking-service/models"
+	"database/sql"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Purposes of emailed user tokens
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+type UserTokenRepository struct {
+	db *sql.DB
+}
+
+func NewUserTokenRepository(db *sql.DB) *UserTokenRepository {
+	return &UserTokenRepository{db: db}
+}
+
+// ReplaceToken stores a new token for the purpose, sent to email, and
+// invalidates any earlier unused ones
+func (tr *UserTokenRepository) ReplaceToken(userID uuid.UUID, purpose, email, tokenHash string, expiresAt time.Time) error {
+	tx, err := tr.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE public.user_token SET used_at = NOW()
+        WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+    `
+	if _, err := tx.Exec(query, userID, purpose); err != nil {
+		return err
+	}
+
+	query = `
+        INSERT INTO public.user_token (id, user_id, purpose, email, token_hash, expires_at, created_at)
+        VALUES ($1, $2, $3, lower($4), $5, $6, NOW())
+    `
+	if _, err := tx.Exec(query, uuid.New(), userID, purpose, email, tokenHash, expiresAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConsumeToken marks a valid token as used and returns its user and the
+// address it was sent to. It returns sql.ErrNoRows if the token is unknown,
+// expired or already used, or the user's username is no longer that address.
+func (tr *UserTokenRepository) ConsumeToken(purpose, tokenHash string) (uuid.UUID, string, error) {
+	query := `
+        UPDATE public.user_token t SET used_at = NOW()
+        FROM public."user" u
+        WHERE t.purpose = $1 AND t.token_hash = $2 AND t.used_at IS NULL AND t.expires_at > NOW()
+          AND u.id = t.user_id AND u.deleted_at IS NULL AND lower(u.username) = t.email
+        RETURNING t.user_id, t.email
+    `
+	var userID uuid.UUID
+	var email string
+	err := tr.db.QueryRow(query, purpose, tokenHash).Scan(&userID, &email)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return userID, email, nil
+}
+
+// GetTokensByUser returns every token emailed to the user, newest first
+func (tr *UserTokenRepository) GetTokensByUser(userID uuid.UUID) ([]models.UserToken, error) {
+	query := `
+        SELECT id, purpose, coalesce(email, ''), expires_at, used_at, created_at
+        FROM public.user_token
+        WHERE user_id = $1
+        ORDER BY created_at DESC
+    `
+	rows, err := tr.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.UserToken{}
+	for rows.Next() {
+		var token models.UserToken
+		if err := rows.Scan(&token.ID, &token.Purpose, &token.Email, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// discardStaleUserTokens deletes the user's unused tokens sent to any address
+// other than their current username, which is already lowercase
+func discardStaleUserTokens(tx *sql.Tx, userID uuid.UUID, username string) error {
+	query := `
+        DELETE FROM public.user_token
+        WHERE user_id = $1 AND used_at IS NULL AND email IS DISTINCT FROM $2
+    `
+	_, err := tx.Exec(query, userID, username)
+	return err
+}